@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upper bound on the number of buckets one aggregate query may return
+const aggregateMaxBuckets = 100000
+
+// History metric names as used in HistoryPoint JSON, in rollupMetrics order
+var historyMetricNames = []string{"cpu", "mem", "disk", "net_rx", "net_tx", "disk_read", "disk_write"}
+
+// aggregateBucket holds the results of one bucket, indexed [metric][fn] in request order
+type aggregateBucket struct {
+	Timestamp int64
+	Samples   int
+	Values    [][]float64
+}
+
+// parseAggregateStep parses a bucket size such as "300", "5m", "1h" or "7d"
+func parseAggregateStep(s string) (int64, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if secs <= 0 {
+			return 0, fmt.Errorf("step must be positive")
+		}
+		return secs, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(s, "d"), 10, 64)
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid step %q", s)
+		}
+		return days * 24 * 60 * 60, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid step %q", s)
+	}
+	return int64(d.Seconds()), nil
+}
+
+// parseAggregateMetrics maps metric names to rollupMetrics indexes (all metrics if empty)
+func parseAggregateMetrics(s string) ([]int, error) {
+	if s == "" {
+		all := make([]int, len(historyMetricNames))
+		for i := range all {
+			all[i] = i
+		}
+		return all, nil
+	}
+	var result []int
+	for _, name := range splitList(s) {
+		idx := -1
+		for i, n := range historyMetricNames {
+			if n == name {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown metric %q (use %s)", name, strings.Join(historyMetricNames, ", "))
+		}
+		result = append(result, idx)
+	}
+	return result, nil
+}
+
+// parseAggregateFuncs validates the requested functions: avg, min, max, sum, count or pN (e.g. p95, p99.9)
+func parseAggregateFuncs(s string) ([]string, error) {
+	if s == "" {
+		return []string{"avg"}, nil
+	}
+	fns := splitList(s)
+	for _, fn := range fns {
+		switch fn {
+		case "avg", "min", "max", "sum", "count":
+			continue
+		}
+		if _, ok := percentileRank(fn); !ok {
+			return nil, fmt.Errorf("unknown function %q (use avg, min, max, sum, count or pN)", fn)
+		}
+	}
+	return fns, nil
+}
+
+// percentileRank parses a percentile function name such as p95
+func percentileRank(fn string) (float64, bool) {
+	if !strings.HasPrefix(fn, "p") {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(fn[1:], 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, false
+	}
+	return p, true
+}
+
+// aggregateValue applies one function to the sorted values of a bucket
+func aggregateValue(sorted []float64, fn string) float64 {
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	switch fn {
+	case "avg":
+		return sum / float64(len(sorted))
+	case "min":
+		return sorted[0]
+	case "max":
+		return sorted[len(sorted)-1]
+	case "sum":
+		return sum
+	case "count":
+		return float64(len(sorted))
+	}
+	p, _ := percentileRank(fn)
+	return percentile(sorted, p)
+}
+
+// aggregatePoints buckets raw points (sorted by timestamp) and applies the functions
+func aggregatePoints(points []HistoryPoint, step int64, metrics []int, fns []string) []aggregateBucket {
+	var result []aggregateBucket
+	for i := 0; i < len(points); {
+		bucket := points[i].Timestamp / step * step
+		j := i
+		for j < len(points) && points[j].Timestamp/step*step == bucket {
+			j++
+		}
+
+		b := aggregateBucket{Timestamp: bucket, Samples: j - i}
+		for _, m := range metrics {
+			values := make([]float64, 0, j-i)
+			for _, p := range points[i:j] {
+				values = append(values, historyValues(p)[m])
+			}
+			sort.Float64s(values)
+			row := make([]float64, len(fns))
+			for k, fn := range fns {
+				row[k] = aggregateValue(values, fn)
+			}
+			b.Values = append(b.Values, row)
+		}
+		result = append(result, b)
+		i = j
+	}
+	return result
+}
+
+// aggregateFromDB computes buckets from the history table
+// avg, min, max, sum and count are computed by SQLite; percentiles need the raw values.
+// Buckets holding points still queued for the next flush are computed from the raw values too.
+func aggregateFromDB(startTime, endTime, step int64, metrics []int, fns []string) ([]aggregateBucket, error) {
+	var exprs []string
+	for _, m := range metrics {
+		for _, fn := range fns {
+			if _, ok := percentileRank(fn); ok {
+				points, err := queryHistoryFromDB(startTime, endTime)
+				if err != nil {
+					return nil, err
+				}
+				return aggregatePoints(points, step, metrics, fns), nil
+			}
+			exprs = append(exprs, fmt.Sprintf("%s(%s)", strings.ToUpper(fn), rollupMetrics[m]))
+		}
+	}
+
+	buckets, firstQueued, err := aggregateHistoryTable(startTime, endTime, step, exprs, metrics, fns)
+	if err != nil || firstQueued < 0 {
+		return buckets, err
+	}
+	from := firstQueued / step * step
+	points, err := queryHistoryFromDB(max(from, startTime), endTime)
+	if err != nil {
+		return nil, err
+	}
+	i := sort.Search(len(buckets), func(i int) bool { return buckets[i].Timestamp >= from })
+	return append(buckets[:i], aggregatePoints(points, step, metrics, fns)...), nil
+}
+
+// aggregateHistoryTable runs the SQL aggregation and returns the timestamp of the oldest
+// queued point in the range (-1 if none), read under the same lock
+func aggregateHistoryTable(startTime, endTime, step int64, exprs []string, metrics []int, fns []string) ([]aggregateBucket, int64, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, -1, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT timestamp / ? * ? AS bucket, COUNT(*), %s FROM history WHERE timestamp >= ? AND timestamp <= ? GROUP BY bucket ORDER BY bucket ASC", strings.Join(exprs, ", ")),
+		step, step, startTime, endTime,
+	)
+	if err != nil {
+		return nil, -1, err
+	}
+	defer rows.Close()
+
+	var result []aggregateBucket
+	for rows.Next() {
+		var b aggregateBucket
+		flat := make([]float64, len(exprs))
+		dest := []interface{}{&b.Timestamp, &b.Samples}
+		for i := range flat {
+			dest = append(dest, &flat[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, -1, err
+		}
+		for i := range metrics {
+			b.Values = append(b.Values, flat[i*len(fns):(i+1)*len(fns)])
+		}
+		result = append(result, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, -1, err
+	}
+
+	firstQueued := int64(-1)
+	for _, p := range pendingHistoryPoints() {
+		if p.Timestamp >= startTime && p.Timestamp <= endTime {
+			firstQueued = p.Timestamp
+			break
+		}
+	}
+	return result, firstQueued, nil
+}
+
+// aggregateFromRollups merges rollup buckets of a tier into buckets of step seconds
+// (step must be a multiple of the tier step; percentiles are only exact for p95 at the tier's own step)
+func aggregateFromRollups(t rollupTier, startTime, endTime, step int64, metrics []int, fns []string) ([]aggregateBucket, error) {
+	for _, fn := range fns {
+		if _, ok := percentileRank(fn); ok && (fn != "p95" || step != t.Step) {
+			return nil, fmt.Errorf("%s is only available for data within the raw retention (rollups store p95 per %s)", fn, t.Name)
+		}
+	}
+	rollups, err := historyStore.QueryRollups(t, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []aggregateBucket
+	for i := 0; i < len(rollups); {
+		bucket := rollups[i].Timestamp / step * step
+		j := i
+		samples := 0
+		for j < len(rollups) && rollups[j].Timestamp/step*step == bucket {
+			samples += rollups[j].Samples
+			j++
+		}
+
+		b := aggregateBucket{Timestamp: bucket, Samples: samples}
+		for _, m := range metrics {
+			minV, maxV, sum := math.Inf(1), math.Inf(-1), 0.0
+			for k := i; k < j; k++ {
+				s := rollups[k].stats()[m]
+				minV = math.Min(minV, s.Min)
+				maxV = math.Max(maxV, s.Max)
+				sum += s.Avg * float64(rollups[k].Samples)
+			}
+			row := make([]float64, len(fns))
+			for k, fn := range fns {
+				switch fn {
+				case "avg":
+					row[k] = sum / float64(samples)
+				case "min":
+					row[k] = minV
+				case "max":
+					row[k] = maxV
+				case "sum":
+					row[k] = sum
+				case "count":
+					row[k] = float64(samples)
+				case "p95":
+					row[k] = rollups[i].stats()[m].P95
+				}
+			}
+			b.Values = append(b.Values, row)
+		}
+		result = append(result, b)
+		i = j
+	}
+	return result, nil
+}
+
+// pickAggregateTier returns the rollup tier for a range older than the raw retention:
+// the tier matching step exactly if it holds startTime, else the finest tier that divides step and
+// holds startTime, else the coarsest tier that divides step (nil if none does)
+func pickAggregateTier(startTime, step int64) *rollupTier {
+	var tier *rollupTier
+	for i := range rollupTiers {
+		t := &rollupTiers[i]
+		if t.Step == step && retentionCovers(t.Name, startTime) {
+			return t
+		}
+		if step%t.Step == 0 && (tier == nil || !retentionCovers(tier.Name, startTime)) {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// handleHistoryAggregate returns bucketed statistics for a time range
+// Recent windows are computed from the memory buffer, older ones from the history table,
+// and ranges beyond the raw retention from the coarsest rollup that fits the step
+// (file and memory stores are aggregated from their raw points)
+func handleHistoryAggregate(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	writeError := func(status int, err error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	}
+
+	// Time range (same parameters as /api/history)
+	endTime := time.Now().Unix()
+	var startTime int64
+	if startStr := query.Get("start"); startStr != "" {
+		startTime, _ = strconv.ParseInt(startStr, 10, 64)
+		if endStr := query.Get("end"); endStr != "" {
+			endTime, _ = strconv.ParseInt(endStr, 10, 64)
+		}
+	} else {
+		minutes := 60
+		if m := query.Get("minutes"); m != "" {
+			if v, err := strconv.Atoi(m); err == nil && v > 0 {
+				minutes = v
+			}
+		}
+		startTime = endTime - int64(minutes)*60
+	}
+	if endTime < startTime {
+		writeError(http.StatusBadRequest, fmt.Errorf("end must not be before start"))
+		return
+	}
+
+	stepStr := query.Get("step")
+	if stepStr == "" {
+		stepStr = "1h"
+	}
+	step, err := parseAggregateStep(stepStr)
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	if (endTime-startTime)/step > aggregateMaxBuckets {
+		writeError(http.StatusBadRequest, fmt.Errorf("too many buckets, use a larger step"))
+		return
+	}
+	metrics, err := parseAggregateMetrics(query.Get("metric"))
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+	fns, err := parseAggregateFuncs(query.Get("fn"))
+	if err != nil {
+		writeError(http.StatusBadRequest, err)
+		return
+	}
+
+	// Pick the source
+	var buckets []aggregateBucket
+	source := "database"
+	if recent := historyBuffer.GetAll(); len(recent) > 0 && recent[0].Timestamp <= startTime {
+		var points []HistoryPoint
+		for _, p := range recent {
+			if p.Timestamp >= startTime && p.Timestamp <= endTime {
+				points = append(points, p)
+			}
+		}
+		buckets = aggregatePoints(points, step, metrics, fns)
+		source = "memory"
+	} else if historyStore.Name() != historyStoreSQLite {
+		// Stores without SQL or rollup tables are aggregated from their raw points
+		var points []HistoryPoint
+		points, err = historyStore.QueryRange(startTime, endTime)
+		buckets = aggregatePoints(points, step, metrics, fns)
+		source = historyStore.Name()
+	} else if retentionCovers("raw", startTime) {
+		buckets, err = aggregateFromDB(startTime, endTime, step, metrics, fns)
+	} else {
+		tier := pickAggregateTier(startTime, step)
+		if tier == nil {
+			writeError(http.StatusBadRequest, fmt.Errorf("step must be a multiple of 5m for data older than the raw retention"))
+			return
+		}
+		source = "rollup_" + tier.Name
+		buckets, err = aggregateFromRollups(*tier, startTime, endTime, step, metrics, fns)
+		if err != nil {
+			writeError(http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err != nil {
+		writeError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Return CSV format: one column per metric and function
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=sysinfo_aggregate_%d_%d.csv", startTime, endTime))
+
+		writer := csv.NewWriter(w)
+		header := []string{"timestamp", "datetime", "samples"}
+		for _, m := range metrics {
+			for _, fn := range fns {
+				header = append(header, historyMetricNames[m]+"_"+fn)
+			}
+		}
+		writer.Write(header)
+
+		for _, b := range buckets {
+			record := []string{
+				strconv.FormatInt(b.Timestamp, 10),
+				time.Unix(b.Timestamp, 0).Format("2006-01-02 15:04:05"),
+				strconv.Itoa(b.Samples),
+			}
+			for _, row := range b.Values {
+				for _, v := range row {
+					record = append(record, fmt.Sprintf("%.2f", v))
+				}
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		return
+	}
+
+	// Return JSON format (default)
+	data := make([]map[string]interface{}, 0, len(buckets))
+	for _, b := range buckets {
+		item := map[string]interface{}{"ts": b.Timestamp, "samples": b.Samples}
+		for i, m := range metrics {
+			values := make(map[string]float64, len(fns))
+			for k, fn := range fns {
+				values[fn] = b.Values[i][k]
+			}
+			item[historyMetricNames[m]] = values
+		}
+		data = append(data, item)
+	}
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = historyMetricNames[m]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"start_time":   startTime,
+		"end_time":     endTime,
+		"step_seconds": step,
+		"source":       source,
+		"metrics":      names,
+		"functions":    fns,
+		"count":        len(data),
+		"data":         data,
+	})
+}
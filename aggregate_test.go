@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregateFromRollupsIncludesUnrolledBuckets(t *testing.T) {
+	store := newTestSQLiteStore(t)
+	prevStore := historyStore
+	historyStore = store
+	t.Cleanup(func() { historyStore = prevStore })
+	withRawRetention(t, 48*time.Hour)
+
+	// Three days every 5 minutes up to now, so the current hour is never rolled up
+	now := time.Now()
+	for i := 72 * 12; i >= 0; i-- {
+		if err := store.WritePoint(testPoint(now.Add(-time.Duration(i)*5*time.Minute), float64(i%100))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Prune(now); err != nil {
+		t.Fatal(err)
+	}
+
+	startTime, endTime := now.Add(-72*time.Hour).Unix(), now.Unix()
+	if retentionCovers("raw", startTime) {
+		t.Fatal("range starts within the raw retention")
+	}
+	tier := pickAggregateTier(startTime, 3600)
+	if tier == nil || tier.Name != "1h" {
+		t.Fatalf("pickAggregateTier = %+v, want the 1h tier", tier)
+	}
+	buckets, err := aggregateFromRollups(*tier, startTime, endTime, 3600, []int{0}, []string{"avg", "count"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) == 0 {
+		t.Fatal("no buckets")
+	}
+
+	last := buckets[len(buckets)-1]
+	if want := endTime / 3600 * 3600; last.Timestamp != want {
+		t.Errorf("last bucket starts at %d, want the current hour %d", last.Timestamp, want)
+	}
+	if want := int((endTime-endTime/3600*3600)/300) + 1; last.Samples != want {
+		t.Errorf("last bucket has %d samples, want %d", last.Samples, want)
+	}
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].Timestamp != buckets[i-1].Timestamp+3600 {
+			t.Errorf("gap between buckets %d and %d", buckets[i-1].Timestamp, buckets[i].Timestamp)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// AlertRule describes a threshold condition on a single metric
+type AlertRule struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Enabled        bool     `json:"enabled"`
+	Metric         string   `json:"metric"`                    // See alertMetricValue for supported names
+	Comparison     string   `json:"comparison"`                // ">", ">=", "<", "<="
+	Threshold      float64  `json:"threshold"`                 // Fires when the comparison holds
+	ClearThreshold *float64 `json:"clear_threshold,omitempty"` // Resolves when crossed back (default: threshold)
+	For            Duration `json:"for"`                       // Condition must hold this long before firing
+	Webhooks       []string `json:"webhooks,omitempty"`        // Webhook names to notify (default: all)
+}
+
+// WebhookConfig is a generic HTTP endpoint receiving alert events
+type WebhookConfig struct {
+	Name       string            `json:"name"`
+	Enabled    bool              `json:"enabled"`
+	URL        string            `json:"url"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Template   string            `json:"template,omitempty"` // Go text/template producing the JSON body (default: the event)
+	MaxRetries int               `json:"max_retries"`
+}
+
+// AlertConfig is persisted to alerts_config.json
+type AlertConfig struct {
+	Rules    []AlertRule     `json:"rules"`
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+// AlertEvent is sent to webhooks when an alert fires or resolves
+type AlertEvent struct {
+	Status     string  `json:"status"` // "firing" or "resolved"
+	RuleID     string  `json:"rule_id"`
+	RuleName   string  `json:"rule_name"`
+	Hostname   string  `json:"hostname"`
+	Metric     string  `json:"metric"`
+	Comparison string  `json:"comparison"`
+	Threshold  float64 `json:"threshold"`
+	Value      float64 `json:"value"`
+	StartsAt   int64   `json:"starts_at"`
+	EndsAt     int64   `json:"ends_at,omitempty"`
+	Timestamp  int64   `json:"timestamp"`
+}
+
+// alertState tracks the evaluation state of one rule
+type alertState struct {
+	PendingSince int64   `json:"pending_since,omitempty"`
+	Firing       bool    `json:"firing"`
+	FiringSince  int64   `json:"firing_since,omitempty"`
+	Value        float64 `json:"value"`
+}
+
+var (
+	alertConfig AlertConfig
+	alertStates = make(map[string]*alertState)
+	alertMutex  sync.RWMutex
+)
+
+// Webhook delivery backoff limits
+const (
+	webhookInitialBackoff = 2 * time.Second
+	webhookMaxBackoff     = 60 * time.Second
+	webhookTimeout        = 10 * time.Second
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// getAlertConfigPath returns the path to the alert rules file
+func getAlertConfigPath() string {
+	return filepath.Join(getDataDir(), "alerts_config.json")
+}
+
+// loadAlertConfig loads alert rules and webhooks from file
+func loadAlertConfig() error {
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+
+	data, err := os.ReadFile(getAlertConfigPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			alertConfig = AlertConfig{Rules: []AlertRule{}, Webhooks: []WebhookConfig{}}
+			return saveAlertConfigLocked()
+		}
+		return err
+	}
+	// Rules are only applied if all of them are valid
+	var cfg AlertConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+	for _, rule := range cfg.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	// Rules added by hand may lack an ID (or copy one); give them a new one and persist it
+	seen := make(map[string]bool)
+	assigned := false
+	for i := range cfg.Rules {
+		if cfg.Rules[i].ID == "" || seen[cfg.Rules[i].ID] {
+			cfg.Rules[i].ID = newAlertID()
+			assigned = true
+		}
+		seen[cfg.Rules[i].ID] = true
+	}
+	if cfg.Rules == nil {
+		cfg.Rules = []AlertRule{}
+	}
+	if cfg.Webhooks == nil {
+		cfg.Webhooks = []WebhookConfig{}
+	}
+	alertConfig = cfg
+	if assigned {
+		return saveAlertConfigLocked()
+	}
+	return nil
+}
+
+// saveAlertConfigLocked saves alert config (must hold alertMutex)
+func saveAlertConfigLocked() error {
+	data, err := json.MarshalIndent(alertConfig, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(getAlertConfigPath(), data, 0600)
+}
+
+// validate checks a rule for unsupported metrics or comparisons
+func (r *AlertRule) validate() error {
+	if _, ok := alertMetricValue(r.Metric, HistoryPoint{}, &SystemInfo{}); !ok && !strings.HasPrefix(r.Metric, "disk:") {
+		return fmt.Errorf("unsupported metric %q", r.Metric)
+	}
+	switch r.Comparison {
+	case ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("unsupported comparison %q", r.Comparison)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("for must not be negative")
+	}
+	if r.ClearThreshold != nil {
+		above := r.Comparison == ">" || r.Comparison == ">="
+		if above && *r.ClearThreshold > r.Threshold || !above && *r.ClearThreshold < r.Threshold {
+			return fmt.Errorf("clear_threshold must be on the non-firing side of threshold")
+		}
+	}
+	return nil
+}
+
+// newAlertID returns a random rule identifier
+func newAlertID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// alertMetricValue extracts a metric from the latest sample
+// Supported: cpu, mem, disk, net_rx, net_tx, disk_read, disk_write,
+// temperature (hottest sensor), disk_await / disk_util (worst device), disk:<mountpoint>
+func alertMetricValue(metric string, point HistoryPoint, info *SystemInfo) (float64, bool) {
+	switch metric {
+	case "cpu":
+		return point.CPUPercent, true
+	case "mem":
+		return point.MemPercent, true
+	case "disk":
+		return point.DiskPercent, true
+	case "net_rx":
+		return point.NetRxRate, true
+	case "net_tx":
+		return point.NetTxRate, true
+	case "disk_read":
+		return point.DiskReadRate, true
+	case "disk_write":
+		return point.DiskWriteRate, true
+	case "temperature":
+		var max float64
+		for _, t := range info.Temperature {
+			if t.Temperature > max {
+				max = t.Temperature
+			}
+		}
+		return max, true
+	case "disk_await", "disk_util":
+		var max float64
+		for _, d := range info.DiskIO.Devices {
+			v := d.AwaitMs
+			if metric == "disk_util" {
+				v = d.UtilPercent
+			}
+			if v > max {
+				max = v
+			}
+		}
+		return max, true
+	}
+	if mountpoint, ok := strings.CutPrefix(metric, "disk:"); ok {
+		for _, d := range info.Disks {
+			if d.Mountpoint == mountpoint {
+				return d.UsedPercent, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// conditionHolds reports whether value triggers the rule
+func (r *AlertRule) conditionHolds(value float64) bool {
+	switch r.Comparison {
+	case ">":
+		return value > r.Threshold
+	case ">=":
+		return value >= r.Threshold
+	case "<":
+		return value < r.Threshold
+	case "<=":
+		return value <= r.Threshold
+	}
+	return false
+}
+
+// clearHolds reports whether a firing alert has recovered past the clear threshold
+func (r *AlertRule) clearHolds(value float64) bool {
+	clear := r.Threshold
+	if r.ClearThreshold != nil {
+		clear = *r.ClearThreshold
+	}
+	if r.Comparison == ">" || r.Comparison == ">=" {
+		return value < clear
+	}
+	return value > clear
+}
+
+// evaluateAlerts checks all rules against the latest sample and notifies webhooks
+func evaluateAlerts(point HistoryPoint, info *SystemInfo) {
+	alertMutex.Lock()
+	var events []AlertEvent
+	var eventRules []AlertRule
+	now := point.Timestamp
+
+	for _, rule := range alertConfig.Rules {
+		if !rule.Enabled {
+			if event, ok := resolveAlertLocked(rule, info.Host.Hostname, now); ok {
+				events = append(events, event)
+				eventRules = append(eventRules, rule)
+			}
+			continue
+		}
+		value, ok := alertMetricValue(rule.Metric, point, info)
+		if !ok {
+			continue
+		}
+		state := alertStates[rule.ID]
+		if state == nil {
+			state = &alertState{}
+			alertStates[rule.ID] = state
+		}
+		state.Value = value
+
+		event := AlertEvent{
+			RuleID:     rule.ID,
+			RuleName:   rule.Name,
+			Hostname:   info.Host.Hostname,
+			Metric:     rule.Metric,
+			Comparison: rule.Comparison,
+			Threshold:  rule.Threshold,
+			Value:      value,
+			Timestamp:  now,
+		}
+
+		if state.Firing {
+			if rule.clearHolds(value) {
+				event.Status = "resolved"
+				event.StartsAt = state.FiringSince
+				event.EndsAt = now
+				*state = alertState{Value: value}
+				events = append(events, event)
+				eventRules = append(eventRules, rule)
+			}
+			continue
+		}
+
+		if !rule.conditionHolds(value) {
+			state.PendingSince = 0
+			continue
+		}
+		if state.PendingSince == 0 {
+			state.PendingSince = now
+		}
+		if time.Duration(now-state.PendingSince)*time.Second >= time.Duration(rule.For) {
+			state.Firing = true
+			state.FiringSince = now
+			event.Status = "firing"
+			event.StartsAt = now
+			events = append(events, event)
+			eventRules = append(eventRules, rule)
+		}
+	}
+
+	webhooks := make([]WebhookConfig, len(alertConfig.Webhooks))
+	copy(webhooks, alertConfig.Webhooks)
+	alertMutex.Unlock()
+
+	notifyAlertEvents(webhooks, events, eventRules)
+}
+
+// resolveAlertLocked drops the state of a rule that is disabled or deleted (must hold alertMutex)
+// A firing alert yields a resolved event so receivers do not keep it open forever
+func resolveAlertLocked(rule AlertRule, hostname string, now int64) (AlertEvent, bool) {
+	state, ok := alertStates[rule.ID]
+	delete(alertStates, rule.ID)
+	if !ok || !state.Firing {
+		return AlertEvent{}, false
+	}
+	return AlertEvent{
+		Status:     "resolved",
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		Hostname:   hostname,
+		Metric:     rule.Metric,
+		Comparison: rule.Comparison,
+		Threshold:  rule.Threshold,
+		Value:      state.Value,
+		StartsAt:   state.FiringSince,
+		EndsAt:     now,
+		Timestamp:  now,
+	}, true
+}
+
+// notifyAlertEvents logs events and sends them to the webhooks selected by their rules
+func notifyAlertEvents(webhooks []WebhookConfig, events []AlertEvent, rules []AlertRule) {
+	for i, event := range events {
+		log.Printf("Alert %s: %s (%s %s %.2f, value %.2f)\n", event.Status, event.RuleName, event.Metric, event.Comparison, event.Threshold, event.Value)
+		for _, wh := range webhooks {
+			if wh.Enabled && webhookSelected(rules[i], wh.Name) {
+				go deliverWebhook(wh, event)
+			}
+		}
+	}
+}
+
+// webhookSelected reports whether a rule should notify the named webhook
+func webhookSelected(rule AlertRule, name string) bool {
+	if len(rule.Webhooks) == 0 {
+		return true
+	}
+	for _, n := range rule.Webhooks {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// renderWebhookBody renders the webhook template for an event
+func renderWebhookBody(wh WebhookConfig, event AlertEvent) ([]byte, error) {
+	if wh.Template == "" {
+		return json.Marshal(event)
+	}
+	tmpl, err := template.New(wh.Name).Funcs(webhookTemplateFuncs).Parse(wh.Template)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliverWebhook posts an event, retrying with exponential backoff
+func deliverWebhook(wh WebhookConfig, event AlertEvent) {
+	body, err := renderWebhookBody(wh, event)
+	if err != nil {
+		log.Printf("Webhook %s template error: %v\n", wh.Name, err)
+		return
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 0; ; attempt++ {
+		err = postWebhook(wh, body)
+		if err == nil {
+			return
+		}
+		if attempt >= wh.MaxRetries {
+			log.Printf("Webhook %s failed after %d attempts: %v\n", wh.Name, attempt+1, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// postWebhook sends a single webhook request
+func postWebhook(wh WebhookConfig, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, wh.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// handleAlerts returns the currently firing and pending alerts
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	type activeAlert struct {
+		Rule AlertRule `json:"rule"`
+		alertState
+		Status string `json:"status"`
+	}
+
+	alertMutex.RLock()
+	active := []activeAlert{}
+	for _, rule := range alertConfig.Rules {
+		state, ok := alertStates[rule.ID]
+		if !ok || (!state.Firing && state.PendingSince == 0) {
+			continue
+		}
+		a := activeAlert{Rule: rule, alertState: *state, Status: "pending"}
+		if state.Firing {
+			a.Status = "firing"
+		}
+		active = append(active, a)
+	}
+	alertMutex.RUnlock()
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].Status < active[j].Status
+	})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"count":  len(active),
+		"alerts": active,
+	})
+}
+
+// handleAlertRules handles GET (list) and POST (create) for alert rules
+func handleAlertRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		alertMutex.RLock()
+		rules := make([]AlertRule, len(alertConfig.Rules))
+		copy(rules, alertConfig.Rules)
+		alertMutex.RUnlock()
+		json.NewEncoder(w).Encode(rules)
+
+	case http.MethodPost:
+		var rule AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if err := rule.validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		rule.ID = newAlertID()
+
+		alertMutex.Lock()
+		alertConfig.Rules = append(alertConfig.Rules, rule)
+		err := saveAlertConfigLocked()
+		alertMutex.Unlock()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+	}
+}
+
+// handleAlertRule handles GET/PUT/DELETE for /api/alerts/rules/{id}
+func handleAlertRule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := strings.TrimPrefix(r.URL.Path, "/api/alerts/rules/")
+
+	alertMutex.Lock()
+	defer alertMutex.Unlock()
+
+	idx := -1
+	for i, rule := range alertConfig.Rules {
+		if rule.ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "rule not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(alertConfig.Rules[idx])
+
+	case http.MethodPut:
+		var rule AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if err := rule.validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		// Evaluation state is kept so a firing alert resolves against the new thresholds
+		rule.ID = id
+		alertConfig.Rules[idx] = rule
+		if err := saveAlertConfigLocked(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(rule)
+
+	case http.MethodDelete:
+		rule := alertConfig.Rules[idx]
+		alertConfig.Rules = append(alertConfig.Rules[:idx], alertConfig.Rules[idx+1:]...)
+		if err := saveAlertConfigLocked(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		var hostname string
+		if hostInfo, err := getCachedHostInfo(); err == nil {
+			hostname = hostInfo.Hostname
+		}
+		if event, ok := resolveAlertLocked(rule, hostname, time.Now().Unix()); ok {
+			notifyAlertEvents(alertConfig.Webhooks, []AlertEvent{event}, []AlertRule{rule})
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+	}
+}
+
+// handleAlertWebhooks handles GET/POST for the webhook list (header values are masked)
+func handleAlertWebhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		alertMutex.RLock()
+		webhooks := make([]WebhookConfig, 0, len(alertConfig.Webhooks))
+		for _, wh := range alertConfig.Webhooks {
+			masked := wh
+			masked.Headers = make(map[string]string, len(wh.Headers))
+			for k := range wh.Headers {
+				masked.Headers[k] = "***"
+			}
+			webhooks = append(webhooks, masked)
+		}
+		alertMutex.RUnlock()
+		json.NewEncoder(w).Encode(webhooks)
+
+	case http.MethodPost:
+		var webhooks []WebhookConfig
+		if err := json.NewDecoder(r.Body).Decode(&webhooks); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		alertMutex.Lock()
+		defer alertMutex.Unlock()
+
+		existing := make(map[string]WebhookConfig)
+		for _, wh := range alertConfig.Webhooks {
+			existing[wh.Name] = wh
+		}
+		for i := range webhooks {
+			wh := &webhooks[i]
+			if wh.Name == "" || wh.URL == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "webhook name and url are required"})
+				return
+			}
+			if wh.Template != "" {
+				if _, err := template.New(wh.Name).Funcs(webhookTemplateFuncs).Parse(wh.Template); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+					return
+				}
+			}
+			// Keep masked header values unchanged
+			for k, v := range wh.Headers {
+				if v == "***" {
+					wh.Headers[k] = existing[wh.Name].Headers[k]
+				}
+			}
+		}
+		alertConfig.Webhooks = webhooks
+		if err := saveAlertConfigLocked(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+	}
+}
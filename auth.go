@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles: read-only principals may only use safe methods, admins may change settings,
+// ingest principals (pushing agents) may only POST to /api/ingest
+const (
+	roleRead   = "read"
+	roleAdmin  = "admin"
+	roleIngest = "ingest"
+)
+
+// AuthConfig is the "auth" section of the config file
+type AuthConfig struct {
+	Enabled      bool        `json:"enabled"`
+	PublicHealth bool        `json:"public_health"` // Serve /health without credentials
+	Tokens       []APIToken  `json:"tokens"`
+	Users        []BasicUser `json:"users"`
+}
+
+// APIToken is a static bearer token (only its SHA-256 hash is stored)
+type APIToken struct {
+	Name      string `json:"name"`
+	TokenHash string `json:"token_hash"`
+	Role      string `json:"role"`
+}
+
+// BasicUser is an HTTP basic auth account with a bcrypt password hash
+type BasicUser struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	Name string
+	Role string
+}
+
+// Authenticator checks one kind of credential
+// ok is false if the request does not carry this kind of credential at all
+type Authenticator interface {
+	Authenticate(r *http.Request) (p Principal, ok bool, err error)
+}
+
+// bearerAuthenticator validates "Authorization: Bearer <token>"
+type bearerAuthenticator struct {
+	tokens []APIToken
+}
+
+func (a *bearerAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return Principal{}, false, nil
+	}
+	hash := hashToken(strings.TrimSpace(token))
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(t.TokenHash)) == 1 {
+			return Principal{Name: "token:" + t.Name, Role: t.Role}, true, nil
+		}
+	}
+	return Principal{}, true, fmt.Errorf("invalid token")
+}
+
+// basicAuthenticator validates HTTP basic auth against bcrypt hashes
+// Successful checks are cached briefly since bcrypt is deliberately slow
+type basicAuthenticator struct {
+	users map[string]BasicUser
+	mu    sync.Mutex
+	cache map[string]time.Time // sha256(user:password) -> expiry
+}
+
+const basicAuthCacheTTL = 5 * time.Minute
+
+func newBasicAuthenticator(users []BasicUser) *basicAuthenticator {
+	a := &basicAuthenticator{
+		users: make(map[string]BasicUser, len(users)),
+		cache: make(map[string]time.Time),
+	}
+	for _, u := range users {
+		a.users[u.Username] = u
+	}
+	return a
+}
+
+func (a *basicAuthenticator) Authenticate(r *http.Request) (Principal, bool, error) {
+	username, password, found := r.BasicAuth()
+	if !found {
+		return Principal{}, false, nil
+	}
+	user, exists := a.users[username]
+	if !exists {
+		return Principal{}, true, fmt.Errorf("invalid credentials")
+	}
+
+	key := hashToken(username + ":" + password)
+	a.mu.Lock()
+	expiry, cached := a.cache[key]
+	a.mu.Unlock()
+	if cached && time.Now().Before(expiry) {
+		return Principal{Name: username, Role: user.Role}, true, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return Principal{}, true, fmt.Errorf("invalid credentials")
+	}
+	a.mu.Lock()
+	a.cache[key] = time.Now().Add(basicAuthCacheTTL)
+	a.mu.Unlock()
+	return Principal{Name: username, Role: user.Role}, true, nil
+}
+
+// hashToken returns the hex SHA-256 of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isReadOnlyMethod reports whether a method never changes state
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// authMiddleware wraps the HTTP handlers with authentication and role checks
+func authMiddleware(cfg AuthConfig, next http.Handler) http.Handler {
+	if !cfg.Enabled {
+		return next
+	}
+	authenticators := []Authenticator{
+		&bearerAuthenticator{tokens: cfg.Tokens},
+		newBasicAuthenticator(cfg.Users),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.PublicHealth && r.URL.Path == "/health" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var principal Principal
+		authenticated := false
+		for _, a := range authenticators {
+			p, ok, err := a.Authenticate(r)
+			if !ok {
+				continue
+			}
+			if err != nil {
+				break
+			}
+			principal = p
+			authenticated = true
+			break
+		}
+
+		if !authenticated {
+			w.Header().Set("WWW-Authenticate", `Basic realm="System Monitor", charset="UTF-8"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unauthorized"})
+			return
+		}
+		if principal.Role == roleIngest {
+			if r.URL.Path != "/api/ingest" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": "ingest role may only push metrics"})
+				return
+			}
+		} else if principal.Role != roleAdmin && !isReadOnlyMethod(r.Method) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "admin role required"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validate checks roles and duplicate names
+func (a *AuthConfig) validate() error {
+	names := make(map[string]bool)
+	for _, t := range a.Tokens {
+		if t.Role != roleRead && t.Role != roleAdmin && t.Role != roleIngest {
+			return fmt.Errorf("token %q: role must be %q, %q or %q", t.Name, roleRead, roleAdmin, roleIngest)
+		}
+		if len(t.TokenHash) != sha256.Size*2 {
+			return fmt.Errorf("token %q: token_hash must be a hex SHA-256", t.Name)
+		}
+		if names["token:"+t.Name] {
+			return fmt.Errorf("duplicate token %q", t.Name)
+		}
+		names["token:"+t.Name] = true
+	}
+	for _, u := range a.Users {
+		if u.Role != roleRead && u.Role != roleAdmin && u.Role != roleIngest {
+			return fmt.Errorf("user %q: role must be %q, %q or %q", u.Username, roleRead, roleAdmin, roleIngest)
+		}
+		if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+			return fmt.Errorf("user %q: password_hash must be a bcrypt hash", u.Username)
+		}
+		if names["user:"+u.Username] {
+			return fmt.Errorf("duplicate user %q", u.Username)
+		}
+		names["user:"+u.Username] = true
+	}
+	if a.Enabled && len(a.Tokens) == 0 && len(a.Users) == 0 {
+		return fmt.Errorf("auth is enabled but no tokens or users are configured")
+	}
+	return nil
+}
+
+// redacted returns a copy with hashes masked
+func (a AuthConfig) redacted() AuthConfig {
+	tokens := make([]APIToken, len(a.Tokens))
+	for i, t := range a.Tokens {
+		t.TokenHash = "***"
+		tokens[i] = t
+	}
+	users := make([]BasicUser, len(a.Users))
+	for i, u := range a.Users {
+		u.PasswordHash = "***"
+		users[i] = u
+	}
+	a.Tokens = tokens
+	a.Users = users
+	return a
+}
+
+// resolveConfigPath returns the config file path used by CLI subcommands
+func resolveConfigPath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if v := os.Getenv("SYSINFO_CONFIG"); v != "" {
+		return v
+	}
+	dir := defaultDataDir()
+	if v := os.Getenv(envName("data-dir")); v != "" {
+		dir = v
+	}
+	return findConfigFile(dir)
+}
+
+// readConfigFileAuth reads the raw config file and its "auth" section
+func readConfigFileAuth(path string) (map[string]json.RawMessage, AuthConfig, error) {
+	raw := make(map[string]json.RawMessage)
+	auth := AuthConfig{PublicHealth: true}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if data, err = configFileJSON(path, data); err != nil {
+			return nil, auth, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, auth, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, auth, err
+	}
+
+	if section, ok := raw["auth"]; ok {
+		if err := json.Unmarshal(section, &auth); err != nil {
+			return nil, auth, fmt.Errorf("failed to parse auth section: %w", err)
+		}
+	}
+	return raw, auth, nil
+}
+
+// updateConfigFileAuth rewrites only the "auth" section of the config file
+func updateConfigFileAuth(path string, update func(a *AuthConfig) error) error {
+	if !isJSONConfigFile(path) {
+		return fmt.Errorf("%s is not a JSON config file; edit its auth section by hand", path)
+	}
+	raw, auth, err := readConfigFileAuth(path)
+	if err != nil {
+		return err
+	}
+	if err := update(&auth); err != nil {
+		return err
+	}
+	if err := auth.validate(); err != nil {
+		return err
+	}
+
+	if raw["auth"], err = json.Marshal(auth); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, out, 0600)
+}
+
+// runAuthCommand implements the "token" and "user" subcommands
+//
+//	token add -name N [-role read|admin|ingest]   generate a token (printed once)
+//	token remove -name N
+//	token list
+//	user add -username U [-role read|admin|ingest]  (password read from stdin)
+//	user remove -username U
+//	user list
+func runAuthCommand(kind string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s add|remove|list [flags]", kind)
+	}
+	action := args[0]
+	fs := flag.NewFlagSet(kind+" "+action, flag.ContinueOnError)
+	configFlag := fs.String("config", "", "config file path")
+	name := fs.String("name", "", "token name")
+	username := fs.String("username", "", "user name")
+	role := fs.String("role", roleRead, "role: read, admin or ingest")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	path := resolveConfigPath(*configFlag)
+
+	switch kind + " " + action {
+	case "token add":
+		if *name == "" {
+			return fmt.Errorf("-name is required")
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token := hex.EncodeToString(b)
+		err := updateConfigFileAuth(path, func(a *AuthConfig) error {
+			a.Tokens = append(a.Tokens, APIToken{Name: *name, TokenHash: hashToken(token), Role: *role})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Token %q (%s) added to %s\n%s\n", *name, *role, path, token)
+		fmt.Println("Store it now, it cannot be shown again. Restart the service to apply.")
+
+	case "token remove":
+		return updateConfigFileAuth(path, func(a *AuthConfig) error {
+			for i, t := range a.Tokens {
+				if t.Name == *name {
+					a.Tokens = append(a.Tokens[:i], a.Tokens[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("token %q not found", *name)
+		})
+
+	case "user add":
+		if *username == "" {
+			return fmt.Errorf("-username is required")
+		}
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			return fmt.Errorf("password must not be empty")
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		err = updateConfigFileAuth(path, func(a *AuthConfig) error {
+			for i, u := range a.Users {
+				if u.Username == *username {
+					a.Users[i] = BasicUser{Username: *username, PasswordHash: string(hash), Role: *role}
+					return nil
+				}
+			}
+			a.Users = append(a.Users, BasicUser{Username: *username, PasswordHash: string(hash), Role: *role})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("User %q (%s) saved to %s. Restart the service to apply.\n", *username, *role, path)
+
+	case "user remove":
+		return updateConfigFileAuth(path, func(a *AuthConfig) error {
+			for i, u := range a.Users {
+				if u.Username == *username {
+					a.Users = append(a.Users[:i], a.Users[i+1:]...)
+					return nil
+				}
+			}
+			return fmt.Errorf("user %q not found", *username)
+		})
+
+	case "token list":
+		_, auth, err := readConfigFileAuth(path)
+		if err != nil {
+			return err
+		}
+		for _, t := range auth.Tokens {
+			fmt.Printf("%s\t%s\n", t.Name, t.Role)
+		}
+
+	case "user list":
+		_, auth, err := readConfigFileAuth(path)
+		if err != nil {
+			return err
+		}
+		for _, u := range auth.Users {
+			fmt.Printf("%s\t%s\n", u.Username, u.Role)
+		}
+
+	default:
+		return fmt.Errorf("unknown command %q", kind+" "+action)
+	}
+	return nil
+}
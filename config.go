@@ -0,0 +1,554 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a time.Duration that reads/writes JSON as "30s" (plain numbers are seconds)
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var secs float64
+		if err := json.Unmarshal(data, &secs); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	return d.Set(s)
+}
+
+// Set parses a duration string such as "30s" or "5m"
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config holds all runtime settings (file < environment < command line flags)
+type Config struct {
+	Listen             string          `json:"listen"`
+	DataDir            string          `json:"data_dir"`
+	DBPath             string          `json:"db_path"`
+	DBFlushInterval    Duration        `json:"db_flush_interval"` // 0: commit every point
+	HistoryInterval    Duration        `json:"history_interval"`
+	HistoryMaxSize     int             `json:"history_max_size"`
+	SysInfoCacheTTL    Duration        `json:"sysinfo_cache_ttl"`
+	ProcessCacheTTL    Duration        `json:"process_cache_ttl"`
+	CPUCollectInterval Duration        `json:"cpu_collect_interval"`
+	NetCollectInterval Duration        `json:"net_collect_interval"`
+	DiskIOInterval     Duration        `json:"disk_io_collect_interval"`
+	EnableTemperature  bool            `json:"enable_temperature"`
+	DiskFstypes        []string        `json:"disk_fstypes"`
+	DiskMountpoints    []string        `json:"disk_mountpoints"`
+	DiskDevices        []string        `json:"disk_devices"`
+	Auth               AuthConfig      `json:"auth"`
+	TLS                TLSConfig       `json:"tls"`
+	Hub                HubConfig       `json:"hub"`
+	Push               PushConfig      `json:"push"`
+	Influx             InfluxConfig    `json:"influx"`
+	OTLP               OTLPConfig      `json:"otlp"`
+	Graphite           GraphiteConfig  `json:"graphite"`
+	StatsD             StatsDConfig    `json:"statsd"`
+	Retention          RetentionConfig `json:"retention"`
+	HistoryStore       string          `json:"history_store"` // sqlite, file or memory
+}
+
+// appConfig is the effective configuration used by program.run
+var appConfig = defaultConfig()
+
+// configPath is the config file that was loaded (empty if none)
+var configPath string
+
+// defaultConfig returns the built-in defaults
+func defaultConfig() Config {
+	return Config{
+		Listen:             ":8088",
+		DBFlushInterval:    Duration(dbDefaultFlushInterval),
+		HistoryInterval:    Duration(historyInterval),
+		HistoryMaxSize:     historyMaxSize,
+		SysInfoCacheTTL:    Duration(sysInfoCacheTTL),
+		ProcessCacheTTL:    Duration(processCacheTTL),
+		CPUCollectInterval: Duration(cpuCollectInterval),
+		NetCollectInterval: Duration(netCollectInterval),
+		DiskIOInterval:     Duration(diskIOCollectInterval),
+		EnableTemperature:  enableTemperature,
+		Auth:               AuthConfig{PublicHealth: true},
+		Hub:                HubConfig{StaleAfter: Duration(hubDefaultStaleAfter), Retention: Duration(hubDefaultRetention)},
+		Push:               PushConfig{BatchSize: pushDefaultBatchSize, Gzip: true, Timeout: Duration(pushDefaultTimeout)},
+		Influx:             InfluxConfig{BatchSize: influxDefaultBatchSize, Gzip: true, Timeout: Duration(pushDefaultTimeout)},
+		OTLP:               OTLPConfig{Interval: Duration(otlpDefaultInterval), Timeout: Duration(pushDefaultTimeout), Gzip: true},
+		Graphite:           GraphiteConfig{Template: metricPathDefaultTemplate, Timeout: Duration(graphiteDefaultTimeout)},
+		StatsD:             StatsDConfig{Template: metricPathDefaultTemplate},
+		HistoryStore:       historyStoreSQLite,
+		Retention:          RetentionConfig{Raw: Duration(defaultRawRetention), FiveMinutes: Duration(default5mRetention), Hour: Duration(default1hRetention)},
+	}
+}
+
+// configOption maps a single setting to its flag and environment variable
+type configOption struct {
+	name   string // flag name; env var is SYSINFO_ + upper(name)
+	usage  string
+	isBool bool
+	set    func(c *Config, v string) error
+}
+
+func setDuration(dst *Duration) func(string) error {
+	return func(v string) error { return dst.Set(v) }
+}
+
+var configOptions = []configOption{
+	{name: "listen", usage: "HTTP listen address", set: func(c *Config, v string) error {
+		c.Listen = v
+		return nil
+	}},
+	{name: "data-dir", usage: "directory for config, database and state files", set: func(c *Config, v string) error {
+		c.DataDir = v
+		return nil
+	}},
+	{name: "db-path", usage: "SQLite history database path", set: func(c *Config, v string) error {
+		c.DBPath = v
+		return nil
+	}},
+	{name: "db-flush-interval", usage: "commit buffered history writes at this interval (0: every point)", set: func(c *Config, v string) error {
+		return setDuration(&c.DBFlushInterval)(v)
+	}},
+	{name: "history-interval", usage: "history collection interval", set: func(c *Config, v string) error {
+		return setDuration(&c.HistoryInterval)(v)
+	}},
+	{name: "history-max-size", usage: "number of points kept in the memory buffer", set: func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		c.HistoryMaxSize = n
+		return err
+	}},
+	{name: "history-store", usage: "history storage backend: sqlite, file (append-only, for SD cards) or memory", set: func(c *Config, v string) error {
+		c.HistoryStore = v
+		return nil
+	}},
+	{name: "retention-raw", usage: "how long raw history is kept (0 = forever)", set: func(c *Config, v string) error {
+		return setDuration(&c.Retention.Raw)(v)
+	}},
+	{name: "retention-5m", usage: "how long 5-minute rollups are kept (0 = forever)", set: func(c *Config, v string) error {
+		return setDuration(&c.Retention.FiveMinutes)(v)
+	}},
+	{name: "retention-1h", usage: "how long 1-hour rollups are kept (0 = forever)", set: func(c *Config, v string) error {
+		return setDuration(&c.Retention.Hour)(v)
+	}},
+	{name: "retention-1d", usage: "how long 1-day rollups are kept (0 = forever)", set: func(c *Config, v string) error {
+		return setDuration(&c.Retention.Day)(v)
+	}},
+	{name: "sysinfo-cache-ttl", usage: "system info cache TTL", set: func(c *Config, v string) error {
+		return setDuration(&c.SysInfoCacheTTL)(v)
+	}},
+	{name: "process-cache-ttl", usage: "process list cache TTL", set: func(c *Config, v string) error {
+		return setDuration(&c.ProcessCacheTTL)(v)
+	}},
+	{name: "cpu-collect-interval", usage: "background CPU collection interval", set: func(c *Config, v string) error {
+		return setDuration(&c.CPUCollectInterval)(v)
+	}},
+	{name: "net-collect-interval", usage: "background network collection interval", set: func(c *Config, v string) error {
+		return setDuration(&c.NetCollectInterval)(v)
+	}},
+	{name: "disk-io-collect-interval", usage: "background disk I/O collection interval", set: func(c *Config, v string) error {
+		return setDuration(&c.DiskIOInterval)(v)
+	}},
+	{name: "enable-temperature", usage: "enable temperature monitoring", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.EnableTemperature = b
+		return err
+	}},
+	{name: "auth-enabled", usage: "require authentication for the HTTP API and dashboard", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.Auth.Enabled = b
+		return err
+	}},
+	{name: "tls-cert", usage: "TLS certificate file (enables HTTPS)", set: func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{name: "tls-key", usage: "TLS private key file", set: func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{name: "tls-client-ca", usage: "CA bundle for verifying client certificates (enables mTLS)", set: func(c *Config, v string) error {
+		c.TLS.ClientCAFile = v
+		return nil
+	}},
+	{name: "tls-self-signed", usage: "generate a self-signed certificate if none exists", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.TLS.SelfSigned = b
+		return err
+	}},
+	{name: "hub", usage: "run as hub: ingest metrics from all agents on the MQTT broker", isBool: true, set: func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.Hub.Enabled = b
+		return err
+	}},
+	{name: "hub-stale-after", usage: "mark hub hosts stale after this long without messages", set: func(c *Config, v string) error {
+		return setDuration(&c.Hub.StaleAfter)(v)
+	}},
+	{name: "push-url", usage: "URL to POST history points to (enables the push exporter)", set: func(c *Config, v string) error {
+		c.Push.URL = v
+		return nil
+	}},
+	{name: "push-token", usage: "bearer token for the push exporter", set: func(c *Config, v string) error {
+		c.Push.Token = v
+		return nil
+	}},
+	{name: "push-flush-interval", usage: "batch pushed points and send at this interval (0: every point)", set: func(c *Config, v string) error {
+		return setDuration(&c.Push.FlushInterval)(v)
+	}},
+	{name: "influx-url", usage: "InfluxDB base URL (enables the InfluxDB writer)", set: func(c *Config, v string) error {
+		c.Influx.URL = v
+		return nil
+	}},
+	{name: "influx-database", usage: "InfluxDB 1.x database", set: func(c *Config, v string) error {
+		c.Influx.Database = v
+		return nil
+	}},
+	{name: "influx-org", usage: "InfluxDB 2.x organization", set: func(c *Config, v string) error {
+		c.Influx.Org = v
+		return nil
+	}},
+	{name: "influx-bucket", usage: "InfluxDB 2.x bucket", set: func(c *Config, v string) error {
+		c.Influx.Bucket = v
+		return nil
+	}},
+	{name: "influx-token", usage: "InfluxDB 2.x API token", set: func(c *Config, v string) error {
+		c.Influx.Token = v
+		return nil
+	}},
+	{name: "otlp-endpoint", usage: "OTLP/HTTP endpoint, e.g. http://collector:4318 (enables the OTLP exporter)", set: func(c *Config, v string) error {
+		c.OTLP.Endpoint = v
+		return nil
+	}},
+	{name: "otlp-headers", usage: "comma-separated key=value headers sent with OTLP exports", set: func(c *Config, v string) error {
+		headers, err := parseHeaderList(v)
+		c.OTLP.Headers = headers
+		return err
+	}},
+	{name: "otlp-interval", usage: "OTLP export interval", set: func(c *Config, v string) error {
+		return setDuration(&c.OTLP.Interval)(v)
+	}},
+	{name: "graphite-address", usage: "Graphite plaintext listener host:port, e.g. graphite:2003 (enables the Graphite emitter)", set: func(c *Config, v string) error {
+		c.Graphite.Address = v
+		return nil
+	}},
+	{name: "graphite-template", usage: "Graphite metric path template, e.g. servers.{hostname}.{metric}", set: func(c *Config, v string) error {
+		c.Graphite.Template = v
+		return nil
+	}},
+	{name: "statsd-address", usage: "StatsD server host:port, e.g. statsd:8125 (enables the StatsD emitter)", set: func(c *Config, v string) error {
+		c.StatsD.Address = v
+		return nil
+	}},
+	{name: "statsd-template", usage: "StatsD metric name template, e.g. servers.{hostname}.{metric}", set: func(c *Config, v string) error {
+		c.StatsD.Template = v
+		return nil
+	}},
+	{name: "disk-fstypes", usage: "comma-separated filesystem types to report (default: all)", set: func(c *Config, v string) error {
+		c.DiskFstypes = splitList(v)
+		return nil
+	}},
+	{name: "disk-mountpoints", usage: "comma-separated mountpoint globs to report (default: all)", set: func(c *Config, v string) error {
+		c.DiskMountpoints = splitList(v)
+		return nil
+	}},
+	{name: "disk-devices", usage: "comma-separated device globs to report (default: all)", set: func(c *Config, v string) error {
+		c.DiskDevices = splitList(v)
+		return nil
+	}},
+}
+
+// splitList splits a comma-separated flag value, dropping empty items
+func splitList(v string) []string {
+	var result []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// envName returns the environment variable for a flag name
+func envName(flagName string) string {
+	return "SYSINFO_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// configFileNames are the default config files, tried in this order
+var configFileNames = []string{"sysinfo_config.json", "sysinfo_config.yaml", "sysinfo_config.yml", "sysinfo_config.toml"}
+
+// findConfigFile returns the first default config file in dir (the JSON one if none exists)
+func findConfigFile(dir string) string {
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, configFileNames[0])
+}
+
+// isJSONConfigFile reports whether a config file is JSON (YAML and TOML are chosen by extension)
+func isJSONConfigFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".toml":
+		return false
+	}
+	return true
+}
+
+// configFileJSON converts a YAML or TOML config file to JSON, so every format uses the same keys and duration strings
+func configFileJSON(path string, data []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	default:
+		return data, nil
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return json.Marshal(doc)
+}
+
+// decodeConfigFile parses a JSON, YAML or TOML config file
+// Unknown keys are rejected to catch typos
+func decodeConfigFile(path string, data []byte, cfg *Config) error {
+	data, err := configFileJSON(path, data)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(cfg)
+}
+
+// loadConfig builds the effective configuration from defaults, the config file,
+// environment variables and command line flags (in increasing priority)
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	cfg.DataDir = defaultDataDir()
+
+	fs := flag.NewFlagSet("sysinfo-api", flag.ContinueOnError)
+	configFlag := fs.String("config", "", "config file path (env SYSINFO_CONFIG, default <data dir>/sysinfo_config.{json,yaml,yml,toml})")
+	flagValues := map[string]string{}
+	for _, opt := range configOptions {
+		name := opt.name
+		usage := fmt.Sprintf("%s (env %s)", opt.usage, envName(name))
+		record := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		if opt.isBool {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// Config file: explicit paths must exist, the default one is optional
+	path := *configFlag
+	if path == "" {
+		path = os.Getenv("SYSINFO_CONFIG")
+	}
+	explicit := path != ""
+	if !explicit {
+		dir := cfg.DataDir
+		if v, ok := os.LookupEnv(envName("data-dir")); ok {
+			dir = v
+		}
+		if v, ok := flagValues["data-dir"]; ok {
+			dir = v
+		}
+		path = findConfigFile(dir)
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := decodeConfigFile(path, data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		configPath = path
+	case os.IsNotExist(err) && !explicit:
+		// No config file - defaults apply
+	default:
+		return cfg, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Environment overrides
+	for _, opt := range configOptions {
+		if v, ok := os.LookupEnv(envName(opt.name)); ok {
+			if err := opt.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", envName(opt.name), err)
+			}
+		}
+	}
+
+	// Flag overrides
+	for _, opt := range configOptions {
+		if v, ok := flagValues[opt.name]; ok {
+			if err := opt.set(&cfg, v); err != nil {
+				return cfg, fmt.Errorf("invalid -%s: %w", opt.name, err)
+			}
+		}
+	}
+
+	if cfg.DBPath == "" {
+		cfg.DBPath = filepath.Join(cfg.DataDir, "sysinfo_history.db")
+	}
+	if cfg.TLS.SelfSigned && cfg.TLS.CertFile == "" {
+		cfg.TLS.CertFile = filepath.Join(cfg.DataDir, "sysinfo_cert.pem")
+		cfg.TLS.KeyFile = filepath.Join(cfg.DataDir, "sysinfo_key.pem")
+	}
+
+	return cfg, cfg.validate()
+}
+
+// validate checks the configuration for values that would break startup
+func (c *Config) validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", c.Listen, err)
+	}
+	if c.DataDir == "" {
+		return fmt.Errorf("data_dir must not be empty")
+	}
+	if info, err := os.Stat(c.DataDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("data_dir %q is not a directory", c.DataDir)
+	}
+	if c.HistoryInterval < Duration(time.Second) {
+		return fmt.Errorf("history_interval must be at least 1s")
+	}
+	if c.DBFlushInterval < 0 {
+		return fmt.Errorf("db_flush_interval must not be negative")
+	}
+	if c.HistoryMaxSize <= 0 {
+		return fmt.Errorf("history_max_size must be positive")
+	}
+	for _, pattern := range append(append([]string{}, c.DiskMountpoints...), c.DiskDevices...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid disk glob %q: %w", pattern, err)
+		}
+	}
+	if c.SysInfoCacheTTL < 0 || c.ProcessCacheTTL < 0 {
+		return fmt.Errorf("cache TTLs must not be negative")
+	}
+	if err := c.Auth.validate(); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if err := c.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if c.Hub.Enabled {
+		if err := c.Hub.validate(); err != nil {
+			return fmt.Errorf("hub: %w", err)
+		}
+	}
+	if err := c.Push.validate(); err != nil {
+		return fmt.Errorf("push: %w", err)
+	}
+	if err := c.Influx.validate(); err != nil {
+		return fmt.Errorf("influx: %w", err)
+	}
+	if err := c.OTLP.validate(); err != nil {
+		return fmt.Errorf("otlp: %w", err)
+	}
+	if err := c.Graphite.validate(); err != nil {
+		return fmt.Errorf("graphite: %w", err)
+	}
+	if err := c.StatsD.validate(); err != nil {
+		return fmt.Errorf("statsd: %w", err)
+	}
+	if err := c.Retention.validate(); err != nil {
+		return fmt.Errorf("retention: %w", err)
+	}
+	if err := validateHistoryStore(c.HistoryStore); err != nil {
+		return err
+	}
+	// cpu.Percent blocks for one second per sample
+	if c.CPUCollectInterval < Duration(time.Second) {
+		return fmt.Errorf("cpu_collect_interval must be at least 1s")
+	}
+	if c.NetCollectInterval < Duration(time.Second) {
+		return fmt.Errorf("net_collect_interval must be at least 1s")
+	}
+	if c.DiskIOInterval < Duration(time.Second) {
+		return fmt.Errorf("disk_io_collect_interval must be at least 1s")
+	}
+	return nil
+}
+
+// redacted returns a copy that is safe to expose over the API
+func (c Config) redacted() Config {
+	c.Auth = c.Auth.redacted()
+	c.Push = c.Push.redacted()
+	c.Influx = c.Influx.redacted()
+	c.OTLP = c.OTLP.redacted()
+	return c
+}
+
+// applyConfig copies the effective configuration into the runtime settings
+func applyConfig(c Config) {
+	appConfig = c
+	historyInterval = time.Duration(c.HistoryInterval)
+	historyMaxSize = c.HistoryMaxSize
+	sysInfoCacheTTL = time.Duration(c.SysInfoCacheTTL)
+	processCacheTTL = time.Duration(c.ProcessCacheTTL)
+	cpuCollectInterval = time.Duration(c.CPUCollectInterval)
+	netCollectInterval = time.Duration(c.NetCollectInterval)
+	diskIOCollectInterval = time.Duration(c.DiskIOInterval)
+	enableTemperature = c.EnableTemperature
+	diskFilter = DiskFilter{
+		Fstypes:     c.DiskFstypes,
+		Mountpoints: c.DiskMountpoints,
+		Devices:     c.DiskDevices,
+	}
+	historyBuffer = NewRingBuffer(historyMaxSize)
+}
+
+// handleConfig returns the effective configuration with secrets redacted
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"config_file": configPath,
+		"config":      appConfig.redacted(),
+	})
+}
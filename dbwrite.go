@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Writes kept while the database keeps failing; the oldest are dropped beyond this
+const dbWriteMaxPending = 10000
+
+// Flushes a write may fail in its own transaction before it is dropped
+const dbWriteMaxAttempts = 3
+
+// Default interval between batched commits
+const dbDefaultFlushInterval = time.Minute
+
+// sqliteDSN opens the database in WAL mode with settings that suit flash storage:
+// synchronous=NORMAL only syncs at checkpoints, and writers wait for locks instead of failing
+func sqliteDSN(dbPath string) string {
+	return dbPath + "?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_txlock=immediate"
+}
+
+// dbWrite is one buffered insert
+type dbWrite struct {
+	seq      uint64
+	rows     int
+	data     interface{} // The inserted values, served to readers until committed (see pendingDBWrites)
+	apply    func(tx *sql.Tx) error
+	attempts int // Failed attempts in its own transaction
+}
+
+// DBWriteStats describes the batched database writes
+type DBWriteStats struct {
+	FlushIntervalSeconds float64 `json:"flush_interval_seconds"`
+	PendingRows          int     `json:"pending_rows"`
+	RowsWritten          int64   `json:"rows_written"`
+	Flushes              int64   `json:"flushes"`
+	FailedFlushes        int64   `json:"failed_flushes"`
+	DroppedRows          int64   `json:"dropped_rows"`
+	LastFlushTime        int64   `json:"last_flush_time"`
+	LastFlushMs          float64 `json:"last_flush_ms"`
+	AvgFlushMs           float64 `json:"avg_flush_ms"`
+	MaxFlushMs           float64 `json:"max_flush_ms"`
+}
+
+// dbWrites buffers history inserts so they are committed in one transaction per flush
+// Writes stay in pending until they are committed or dropped; the flush in progress
+// covers the writes up to seq flushing.
+var dbWrites struct {
+	mu         sync.Mutex
+	flushMu    sync.Mutex // Serializes flushes
+	pending    []dbWrite
+	nextSeq    uint64
+	flushing   uint64
+	closed     bool
+	stats      DBWriteStats
+	flushTotal time.Duration
+}
+
+// queueDBWrite buffers an insert until the next flush (written at once if the flush interval is 0)
+// data holds the inserted values, which readers merge into their results until the write is committed
+func queueDBWrite(rows int, data interface{}, apply func(tx *sql.Tx) error) error {
+	dbWrites.mu.Lock()
+	if db == nil || dbWrites.closed {
+		dbWrites.mu.Unlock()
+		return fmt.Errorf("database not initialized")
+	}
+	dbWrites.nextSeq++
+	dbWrites.pending = append(dbWrites.pending, dbWrite{seq: dbWrites.nextSeq, rows: rows, data: data, apply: apply})
+	trimPendingDBWrites()
+	dbWrites.mu.Unlock()
+
+	if appConfig.DBFlushInterval <= 0 {
+		flushDBWrites()
+	}
+	return nil
+}
+
+// trimPendingDBWrites drops the oldest writes beyond dbWriteMaxPending (dbWrites.mu must be held)
+// Writes of the flush in progress are kept; that flush commits or drops them
+func trimPendingDBWrites() {
+	n := len(dbWrites.pending) - dbWriteMaxPending
+	if n <= 0 {
+		return
+	}
+	i := 0
+	for i < len(dbWrites.pending) && dbWrites.pending[i].seq <= dbWrites.flushing {
+		i++
+	}
+	if i+n > len(dbWrites.pending) {
+		n = len(dbWrites.pending) - i
+	}
+	for _, w := range dbWrites.pending[i : i+n] {
+		dbWrites.stats.DroppedRows += int64(w.rows)
+	}
+	dbWrites.pending = append(append([]dbWrite(nil), dbWrites.pending[:i]...), dbWrites.pending[i+n:]...)
+}
+
+// pendingDBWrites returns the data of the writes not committed yet, oldest first
+// Readers call it while holding dbMutex, so a write is either in the database or returned here
+func pendingDBWrites() []interface{} {
+	dbWrites.mu.Lock()
+	defer dbWrites.mu.Unlock()
+	data := make([]interface{}, 0, len(dbWrites.pending))
+	for _, w := range dbWrites.pending {
+		data = append(data, w.data)
+	}
+	return data
+}
+
+// flushDBWrites commits all buffered writes in one transaction
+// If the database cannot be written, the writes stay buffered for the next flush
+func flushDBWrites() {
+	dbWrites.flushMu.Lock()
+	defer dbWrites.flushMu.Unlock()
+
+	dbWrites.mu.Lock()
+	batch := append([]dbWrite(nil), dbWrites.pending...)
+	if len(batch) > 0 {
+		dbWrites.flushing = batch[len(batch)-1].seq
+	}
+	dbWrites.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	// dbMutex is held until the committed writes have left pending, so readers never see a
+	// write both in the database and in the buffer (or in neither)
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	start := time.Now()
+	res := commitDBWrites(batch)
+	elapsed := time.Since(start)
+
+	dbWrites.mu.Lock()
+	defer dbWrites.mu.Unlock()
+	dbWrites.pending = append(res.keep, dbWrites.pending[len(batch):]...)
+	dbWrites.flushing = 0
+	dbWrites.stats.RowsWritten += int64(res.rows)
+	dbWrites.stats.DroppedRows += int64(res.dropped)
+	if res.batchErr != nil {
+		dbWrites.stats.FailedFlushes++
+		log.Printf("Failed to flush %d buffered database writes, retrying them one by one: %v\n", len(batch), res.batchErr)
+	}
+	if res.err != nil {
+		log.Printf("Database unavailable, keeping %d buffered writes: %v\n", len(res.keep), res.err)
+		return
+	}
+	ms := float64(elapsed.Microseconds()) / 1000
+	dbWrites.stats.Flushes++
+	dbWrites.stats.LastFlushTime = start.Unix()
+	dbWrites.stats.LastFlushMs = ms
+	if ms > dbWrites.stats.MaxFlushMs {
+		dbWrites.stats.MaxFlushMs = ms
+	}
+	dbWrites.flushTotal += elapsed
+	dbWrites.stats.AvgFlushMs = float64(dbWrites.flushTotal.Microseconds()) / 1000 / float64(dbWrites.stats.Flushes)
+}
+
+// dbFlushResult is the outcome of committing a batch
+type dbFlushResult struct {
+	keep     []dbWrite // Writes left buffered for the next flush
+	rows     int       // Rows written
+	dropped  int       // Rows of writes that failed dbWriteMaxAttempts times
+	batchErr error     // Why the batch transaction failed (the writes were then retried one by one)
+	err      error     // Set if the database could not be used at all
+}
+
+// commitDBWrites applies a batch in a single transaction (dbMutex must be held)
+// If that transaction fails, every write is retried in its own transaction so one bad write
+// cannot hold back the others; a write that keeps failing is dropped after dbWriteMaxAttempts flushes
+func commitDBWrites(batch []dbWrite) dbFlushResult {
+	var res dbFlushResult
+	if db == nil {
+		res.keep, res.err = batch, fmt.Errorf("database not initialized")
+		return res
+	}
+
+	res.batchErr = applyDBWrites(batch)
+	if res.batchErr == nil {
+		for _, w := range batch {
+			res.rows += w.rows
+		}
+		return res
+	}
+	if errors.Is(res.batchErr, errDBBegin) {
+		res.keep, res.err, res.batchErr = batch, res.batchErr, nil
+		return res
+	}
+
+	for i, w := range batch {
+		err := applyDBWrites(batch[i : i+1])
+		switch {
+		case err == nil:
+			res.rows += w.rows
+		case errors.Is(err, errDBBegin):
+			res.keep, res.err = append(res.keep, batch[i:]...), err
+			return res
+		default:
+			w.attempts++
+			if w.attempts < dbWriteMaxAttempts {
+				res.keep = append(res.keep, w)
+				continue
+			}
+			res.dropped += w.rows
+			log.Printf("Dropping a database write of %d rows after %d failed attempts: %v\n", w.rows, w.attempts, err)
+		}
+	}
+	return res
+}
+
+// errDBBegin marks a transaction that could not be started
+var errDBBegin = errors.New("failed to begin transaction")
+
+// applyDBWrites runs writes in one transaction
+func applyDBWrites(writes []dbWrite) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("%w: %v", errDBBegin, err)
+	}
+	for _, w := range writes {
+		if err := w.apply(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// startDBWriter flushes buffered writes at the configured interval
+func startDBWriter() {
+	interval := time.Duration(appConfig.DBFlushInterval)
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			flushDBWrites()
+		}
+	}()
+	log.Printf("Database writes batched every %v\n", interval)
+}
+
+// closeDBWrites flushes what is left and rejects later writes (called on shutdown)
+func closeDBWrites() {
+	dbWrites.mu.Lock()
+	dbWrites.closed = true
+	dbWrites.mu.Unlock()
+	flushDBWrites()
+
+	dbWrites.mu.Lock()
+	defer dbWrites.mu.Unlock()
+	if n := len(dbWrites.pending); n > 0 {
+		log.Printf("Discarding %d database writes that could not be flushed\n", n)
+	}
+}
+
+// getDBWriteStats returns a snapshot of the write statistics
+func getDBWriteStats() DBWriteStats {
+	dbWrites.mu.Lock()
+	defer dbWrites.mu.Unlock()
+	stats := dbWrites.stats
+	stats.FlushIntervalSeconds = time.Duration(appConfig.DBFlushInterval).Seconds()
+	for _, w := range dbWrites.pending {
+		stats.PendingRows += w.rows
+	}
+	return stats
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withBatchedDBWrites opens a test database with a flush interval that never fires during the test
+func withBatchedDBWrites(t *testing.T) {
+	t.Helper()
+	newTestSQLiteStore(t)
+	prev := appConfig.DBFlushInterval
+	appConfig.DBFlushInterval = Duration(time.Hour)
+	resetDBWrites := func() {
+		dbWrites.mu.Lock()
+		dbWrites.pending = nil
+		dbWrites.flushing = 0
+		dbWrites.closed = false
+		dbWrites.stats = DBWriteStats{}
+		dbWrites.flushTotal = 0
+		dbWrites.mu.Unlock()
+	}
+	resetDBWrites()
+	t.Cleanup(func() {
+		appConfig.DBFlushInterval = prev
+		resetDBWrites()
+	})
+}
+
+// committedRows counts the rows of a table in the database itself
+func committedRows(t *testing.T, table string) int {
+	t.Helper()
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestQueuedWritesAreReadable(t *testing.T) {
+	withBatchedDBWrites(t)
+	base := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	committed := testPoint(base, 1)
+	if err := saveHistoryToDB(committed); err != nil {
+		t.Fatal(err)
+	}
+	flushDBWrites()
+	queued := []HistoryPoint{testPoint(base.Add(10*time.Second), 2), testPoint(base.Add(20*time.Second), 3)}
+	for _, p := range queued {
+		if err := saveHistoryToDB(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	disks := []DiskInfo{{Mountpoint: "/", Device: "sda1", Total: 100, Used: 40, Free: 60, UsedPercent: 40}, {Mountpoint: "/data", Device: "sdb1", Total: 10}}
+	if err := saveDiskHistoryToDB(queued[0].Timestamp, disks); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveDiskIOHistoryToDB(queued[0].Timestamp, []DiskIODeviceInfo{{Name: "sda", ReadBytesPerSec: 512}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads do not flush
+	want := append([]HistoryPoint{committed}, queued...)
+	for i := 0; i < 2; i++ {
+		got, err := queryHistoryFromDB(0, base.Add(time.Hour).Unix())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("queryHistoryFromDB = %+v, want %+v", got, want)
+		}
+		minTime, maxTime, count, err := getHistoryStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if minTime != committed.Timestamp || maxTime != queued[1].Timestamp || count != 3 {
+			t.Errorf("getHistoryStats = %d, %d, %d", minTime, maxTime, count)
+		}
+		diskPoints, err := queryDiskHistoryFromDB(0, base.Add(time.Hour).Unix(), "/data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(diskPoints) != 1 || diskPoints[0].Device != "sdb1" || diskPoints[0].Timestamp != queued[0].Timestamp {
+			t.Errorf("queryDiskHistoryFromDB = %+v", diskPoints)
+		}
+		ioPoints, err := queryDiskIOHistoryFromDB(0, base.Add(time.Hour).Unix(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ioPoints) != 1 || ioPoints[0].ReadBytesPerSec != 512 {
+			t.Errorf("queryDiskIOHistoryFromDB = %+v", ioPoints)
+		}
+
+		if i == 0 {
+			if n := committedRows(t, "history"); n != 1 {
+				t.Fatalf("history holds %d rows before the flush, want 1", n)
+			}
+			if stats := getDBWriteStats(); stats.PendingRows != 5 {
+				t.Errorf("pending rows = %d, want 5", stats.PendingRows)
+			}
+			flushDBWrites()
+		}
+	}
+
+	// After the flush the same rows come from the database only once
+	if n := committedRows(t, "history"); n != 3 {
+		t.Errorf("history holds %d rows after the flush, want 3", n)
+	}
+	if n := committedRows(t, "disk_history"); n != 2 {
+		t.Errorf("disk_history holds %d rows after the flush, want 2", n)
+	}
+	if stats := getDBWriteStats(); stats.PendingRows != 0 || stats.RowsWritten != 6 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestAggregateIncludesQueuedWrites(t *testing.T) {
+	withBatchedDBWrites(t)
+	base := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	var all []HistoryPoint
+	for i := 0; i < 12; i++ {
+		p := testPoint(base.Add(time.Duration(i)*10*time.Minute), float64(i))
+		all = append(all, p)
+		if err := saveHistoryToDB(p); err != nil {
+			t.Fatal(err)
+		}
+		// The second hour is split between the database and the queue
+		if i == 8 {
+			flushDBWrites()
+		}
+	}
+
+	metrics := []int{0, 1}
+	fns := []string{"avg", "min", "max", "sum", "count"}
+	got, err := aggregateFromDB(base.Unix(), base.Add(2*time.Hour).Unix(), 3600, metrics, fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := aggregatePoints(all, 3600, metrics, fns); !reflect.DeepEqual(got, want) {
+		t.Errorf("aggregateFromDB = %+v, want %+v", got, want)
+	}
+	if n := committedRows(t, "history"); n != 9 {
+		t.Errorf("history holds %d rows, want 9 (aggregating must not flush)", n)
+	}
+}
+
+func TestFailedWriteIsRetriedAlone(t *testing.T) {
+	withBatchedDBWrites(t)
+	base := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	good := []HistoryPoint{testPoint(base, 1), testPoint(base.Add(10*time.Second), 2)}
+	if err := saveHistoryToDB(good[0]); err != nil {
+		t.Fatal(err)
+	}
+	bad := errors.New("constraint failed")
+	if err := queueDBWrite(1, nil, func(tx *sql.Tx) error { return bad }); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveHistoryToDB(good[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt <= dbWriteMaxAttempts; attempt++ {
+		flushDBWrites()
+		if n := committedRows(t, "history"); n != 2 {
+			t.Fatalf("flush %d: history holds %d rows, want 2", attempt, n)
+		}
+		stats := getDBWriteStats()
+		if stats.FailedFlushes != int64(attempt) || stats.RowsWritten != 2 {
+			t.Errorf("flush %d: stats = %+v", attempt, stats)
+		}
+		wantPending, wantDropped := 1, int64(0)
+		if attempt == dbWriteMaxAttempts {
+			wantPending, wantDropped = 0, 1
+		}
+		if stats.PendingRows != wantPending || stats.DroppedRows != wantDropped {
+			t.Errorf("flush %d: pending %d, dropped %d, want %d, %d", attempt, stats.PendingRows, stats.DroppedRows, wantPending, wantDropped)
+		}
+	}
+
+	// Later writes are no longer held back
+	next := testPoint(base.Add(20*time.Second), 3)
+	if err := saveHistoryToDB(next); err != nil {
+		t.Fatal(err)
+	}
+	flushDBWrites()
+	got, err := queryHistoryFromDB(0, base.Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(good, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %+v, want %+v", got, want)
+	}
+	if stats := getDBWriteStats(); stats.FailedFlushes != dbWriteMaxAttempts || stats.Flushes != dbWriteMaxAttempts+1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
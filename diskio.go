@@ -0,0 +1,349 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Disk I/O collection interval (overridable via config)
+var diskIOCollectInterval = 5 * time.Second
+
+// DiskIODeviceInfo holds per-device I/O rates computed from counter deltas
+type DiskIODeviceInfo struct {
+	Name             string  `json:"name"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	ReadIOPS         float64 `json:"read_iops"`
+	WriteIOPS        float64 `json:"write_iops"`
+	AwaitMs          float64 `json:"await_ms"`     // Average time per completed I/O
+	UtilPercent      float64 `json:"util_percent"` // Share of wall time the device was busy
+	ReadBytesTotal   uint64  `json:"read_bytes_total"`
+	WriteBytesTotal  uint64  `json:"write_bytes_total"`
+}
+
+// DiskIOInfo is the disk_io section of SystemInfo
+type DiskIOInfo struct {
+	ReadBytesPerSec  float64            `json:"read_bytes_per_sec"`  // Sum over whole disks
+	WriteBytesPerSec float64            `json:"write_bytes_per_sec"` // Sum over whole disks
+	IOPS             float64            `json:"iops"`                // Sum over whole disks
+	Devices          []DiskIODeviceInfo `json:"devices"`
+	Timestamp        int64              `json:"timestamp"`
+}
+
+// Background disk I/O collection cache
+var (
+	diskIOCache      DiskIOInfo
+	diskIOPrev       map[string]disk.IOCountersStat
+	diskIOPrevTime   time.Time
+	diskIOCacheMutex sync.RWMutex
+)
+
+// getCachedDiskIOInfo returns the latest rates from the background collector
+func getCachedDiskIOInfo() DiskIOInfo {
+	diskIOCacheMutex.RLock()
+	defer diskIOCacheMutex.RUnlock()
+
+	result := diskIOCache
+	result.Devices = make([]DiskIODeviceInfo, len(diskIOCache.Devices))
+	copy(result.Devices, diskIOCache.Devices)
+	return result
+}
+
+// isVirtualBlockDevice reports whether a device is a loop/ram device
+func isVirtualBlockDevice(name string) bool {
+	return strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram")
+}
+
+// Linux describes every block device here
+const sysBlockDir = "/sys/class/block"
+
+// Partition suffixes: sda1 of sda; disks ending in a digit need p or s (nvme0n1p1, mmcblk0p1, disk0s1)
+var (
+	partitionSuffix      = regexp.MustCompile(`^[0-9]+$`)
+	digitPartitionSuffix = regexp.MustCompile(`^[ps][0-9]+$`)
+)
+
+// isPartitionOf reports whether name is a partition of another listed device
+// On Linux sysfs knows; elsewhere only explicit suffixes match, so dm-10 is not taken for a partition of dm-1
+func isPartitionOf(name string, devices map[string]disk.IOCountersStat) bool {
+	if runtime.GOOS == "linux" {
+		if _, err := os.Stat(filepath.Join(sysBlockDir, name)); err == nil {
+			_, err := os.Stat(filepath.Join(sysBlockDir, name, "partition"))
+			return err == nil
+		}
+	}
+	for other := range devices {
+		if other == name || !strings.HasPrefix(name, other) {
+			continue
+		}
+		suffix := name[len(other):]
+		if last := other[len(other)-1]; last >= '0' && last <= '9' {
+			if digitPartitionSuffix.MatchString(suffix) {
+				return true
+			}
+		} else if partitionSuffix.MatchString(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStackedDevice reports whether a device is built on other block devices (device-mapper, LVM, md RAID)
+// Their I/O is already counted in the disks below them
+func isStackedDevice(name string) bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	entries, err := os.ReadDir(filepath.Join(sysBlockDir, name, "slaves"))
+	return err == nil && len(entries) > 0
+}
+
+// sampleDiskIO reads block device counters and updates the cached rates
+func sampleDiskIO() {
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	diskIOCacheMutex.Lock()
+	defer diskIOCacheMutex.Unlock()
+
+	// The first sample only establishes the baseline
+	if diskIOPrev == nil {
+		diskIOPrev = counters
+		diskIOPrevTime = now
+		return
+	}
+
+	elapsed := now.Sub(diskIOPrevTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	info := DiskIOInfo{Timestamp: now.Unix()}
+	for name, c := range counters {
+		prev, ok := diskIOPrev[name]
+		if !ok || isVirtualBlockDevice(name) {
+			continue
+		}
+		reads := counterDelta(c.ReadCount, prev.ReadCount)
+		writes := counterDelta(c.WriteCount, prev.WriteCount)
+		ioTimeMs := counterDelta(c.ReadTime, prev.ReadTime) + counterDelta(c.WriteTime, prev.WriteTime)
+
+		dev := DiskIODeviceInfo{
+			Name:             name,
+			ReadBytesPerSec:  float64(counterDelta(c.ReadBytes, prev.ReadBytes)) / elapsed,
+			WriteBytesPerSec: float64(counterDelta(c.WriteBytes, prev.WriteBytes)) / elapsed,
+			ReadIOPS:         float64(reads) / elapsed,
+			WriteIOPS:        float64(writes) / elapsed,
+			UtilPercent:      float64(counterDelta(c.IoTime, prev.IoTime)) / (elapsed * 1000) * 100,
+			ReadBytesTotal:   c.ReadBytes,
+			WriteBytesTotal:  c.WriteBytes,
+		}
+		if reads+writes > 0 {
+			dev.AwaitMs = float64(ioTimeMs) / float64(reads+writes)
+		}
+		if dev.UtilPercent > 100 {
+			dev.UtilPercent = 100
+		}
+		info.Devices = append(info.Devices, dev)
+
+		// Partitions and stacked devices are already counted in the disks below them
+		if !isPartitionOf(name, counters) && !isStackedDevice(name) {
+			info.ReadBytesPerSec += dev.ReadBytesPerSec
+			info.WriteBytesPerSec += dev.WriteBytesPerSec
+			info.IOPS += dev.ReadIOPS + dev.WriteIOPS
+		}
+	}
+	sort.Slice(info.Devices, func(i, j int) bool {
+		return info.Devices[i].Name < info.Devices[j].Name
+	})
+
+	diskIOCache = info
+	diskIOPrev = counters
+	diskIOPrevTime = now
+}
+
+// startDiskIOCollector starts background disk I/O rate collection
+func startDiskIOCollector() {
+	sampleDiskIO()
+
+	go func() {
+		ticker := time.NewTicker(diskIOCollectInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sampleDiskIO()
+		}
+	}()
+}
+
+// handleDiskIO returns the latest per-device disk I/O rates
+func handleDiskIO(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(getCachedDiskIOInfo())
+}
+
+// DiskIOHistoryPoint stores per-device I/O rates for each time point
+type DiskIOHistoryPoint struct {
+	Timestamp        int64   `json:"ts"`
+	Device           string  `json:"device"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	ReadIOPS         float64 `json:"read_iops"`
+	WriteIOPS        float64 `json:"write_iops"`
+	AwaitMs          float64 `json:"await_ms"`
+	UtilPercent      float64 `json:"util_percent"`
+}
+
+// saveDiskIOHistoryToDB queues per-device I/O rates for one time point
+func saveDiskIOHistoryToDB(ts int64, devices []DiskIODeviceInfo) error {
+	if len(devices) == 0 {
+		return nil
+	}
+	points := make([]DiskIOHistoryPoint, 0, len(devices))
+	for _, d := range devices {
+		points = append(points, DiskIOHistoryPoint{
+			Timestamp:        ts,
+			Device:           d.Name,
+			ReadBytesPerSec:  d.ReadBytesPerSec,
+			WriteBytesPerSec: d.WriteBytesPerSec,
+			ReadIOPS:         d.ReadIOPS,
+			WriteIOPS:        d.WriteIOPS,
+			AwaitMs:          d.AwaitMs,
+			UtilPercent:      d.UtilPercent,
+		})
+	}
+	return queueDBWrite(len(points), points, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT INTO disk_io_history (timestamp, device, read_bytes_per_sec, write_bytes_per_sec, read_iops, write_iops, await_ms, util_percent) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, p := range points {
+			if _, err := stmt.Exec(p.Timestamp, p.Device, p.ReadBytesPerSec, p.WriteBytesPerSec, p.ReadIOPS, p.WriteIOPS, p.AwaitMs, p.UtilPercent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// queryDiskIOHistoryFromDB queries per-device I/O history (all devices if device is empty)
+// Points still queued for the next flush are included
+func queryDiskIOHistoryFromDB(startTime, endTime int64, device string) ([]DiskIOHistoryPoint, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	query := "SELECT timestamp, device, read_bytes_per_sec, write_bytes_per_sec, read_iops, write_iops, await_ms, util_percent FROM disk_io_history WHERE timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{startTime, endTime}
+	if device != "" {
+		query += " AND device = ?"
+		args = append(args, device)
+	}
+	query += " ORDER BY timestamp ASC, device ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []DiskIOHistoryPoint
+	for rows.Next() {
+		var p DiskIOHistoryPoint
+		if err := rows.Scan(&p.Timestamp, &p.Device, &p.ReadBytesPerSec, &p.WriteBytesPerSec, &p.ReadIOPS, &p.WriteIOPS, &p.AwaitMs, &p.UtilPercent); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	queued := false
+	for _, d := range pendingDBWrites() {
+		points, _ := d.([]DiskIOHistoryPoint)
+		for _, p := range points {
+			if p.Timestamp >= startTime && p.Timestamp <= endTime && (device == "" || p.Device == device) {
+				result = append(result, p)
+				queued = true
+			}
+		}
+	}
+	if queued {
+		sort.SliceStable(result, func(i, j int) bool {
+			if result[i].Timestamp != result[j].Timestamp {
+				return result[i].Timestamp < result[j].Timestamp
+			}
+			return result[i].Device < result[j].Device
+		})
+	}
+	return result, nil
+}
+
+// handleDiskIOHistory returns per-device disk I/O history
+// Query params:
+//   - minutes: last N minutes (default: 60)
+//   - start/end: Unix timestamp range (overrides minutes)
+//   - device: only this device (default: all)
+func handleDiskIOHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !storesDiskHistory() {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(map[string]string{"error": errNoDiskHistory.Error()})
+		return
+	}
+	query := r.URL.Query()
+
+	endTime := time.Now().Unix()
+	var startTime int64
+	if startStr := query.Get("start"); startStr != "" {
+		startTime, _ = strconv.ParseInt(startStr, 10, 64)
+		if endStr := query.Get("end"); endStr != "" {
+			endTime, _ = strconv.ParseInt(endStr, 10, 64)
+		}
+	} else {
+		minutes := 60
+		if m := query.Get("minutes"); m != "" {
+			if v, err := strconv.Atoi(m); err == nil && v > 0 {
+				minutes = v
+			}
+		}
+		startTime = time.Now().Add(-time.Duration(minutes) * time.Minute).Unix()
+	}
+
+	data, err := queryDiskIOHistoryFromDB(startTime, endTime, query.Get("device"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval_seconds": int(historyInterval.Seconds()),
+		"start_time":       startTime,
+		"end_time":         endTime,
+		"count":            len(data),
+		"data":             data,
+	})
+}
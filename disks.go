@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DiskFilter selects which partitions are reported (empty lists match everything)
+type DiskFilter struct {
+	Fstypes     []string // exact filesystem types, e.g. "ext4", "xfs"
+	Mountpoints []string // mountpoint globs, e.g. "/data*"
+	Devices     []string // device globs, e.g. "/dev/sd*"
+}
+
+var diskFilter DiskFilter
+
+// matches reports whether a partition passes the filter
+func (f DiskFilter) matches(p disk.PartitionStat) bool {
+	if len(f.Fstypes) > 0 {
+		ok := false
+		for _, t := range f.Fstypes {
+			if strings.EqualFold(t, p.Fstype) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return matchAnyGlob(f.Mountpoints, p.Mountpoint) && matchAnyGlob(f.Devices, p.Device)
+}
+
+// matchAnyGlob reports whether s matches one of the patterns (true if there are none)
+func matchAnyGlob(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getDiskInfo returns the primary disk ("/" or "C:") and all partitions passing the filter
+func getDiskInfo(hostOS string) (DiskInfo, []DiskInfo, error) {
+	primaryPath := "/"
+	if hostOS == "windows" {
+		primaryPath = "C:"
+	}
+
+	var primary DiskInfo
+	var disks []DiskInfo
+	primaryFound := false
+
+	partitions, err := disk.Partitions(false)
+	if err != nil {
+		partitions = nil
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range partitions {
+		if seen[p.Mountpoint] || !diskFilter.matches(p) {
+			continue
+		}
+		seen[p.Mountpoint] = true
+
+		usage, err := disk.Usage(p.Mountpoint)
+		if err != nil || usage.Total == 0 {
+			continue
+		}
+		d := newDiskInfo(p, usage)
+		disks = append(disks, d)
+		if strings.EqualFold(strings.TrimSuffix(p.Mountpoint, `\`), primaryPath) {
+			primary = d
+			primaryFound = true
+		}
+	}
+
+	// The primary disk is always reported even if filtered out of the list
+	if !primaryFound {
+		usage, err := disk.Usage(primaryPath)
+		if err != nil {
+			return primary, disks, err
+		}
+		primary = newDiskInfo(disk.PartitionStat{Mountpoint: primaryPath, Fstype: usage.Fstype}, usage)
+	}
+
+	return primary, disks, nil
+}
+
+// newDiskInfo converts gopsutil partition and usage stats to DiskInfo
+func newDiskInfo(p disk.PartitionStat, usage *disk.UsageStat) DiskInfo {
+	return DiskInfo{
+		Mountpoint:        p.Mountpoint,
+		Device:            p.Device,
+		Fstype:            p.Fstype,
+		Total:             usage.Total,
+		Used:              usage.Used,
+		Free:              usage.Free,
+		UsedPercent:       usage.UsedPercent,
+		InodesTotal:       usage.InodesTotal,
+		InodesUsed:        usage.InodesUsed,
+		InodesFree:        usage.InodesFree,
+		InodesUsedPercent: usage.InodesUsedPercent,
+	}
+}
+
+// DiskHistoryPoint stores per-mountpoint usage for each time point
+type DiskHistoryPoint struct {
+	Timestamp         int64   `json:"ts"`
+	Mountpoint        string  `json:"mountpoint"`
+	Device            string  `json:"device"`
+	Total             uint64  `json:"total_bytes"`
+	Used              uint64  `json:"used_bytes"`
+	Free              uint64  `json:"free_bytes"`
+	UsedPercent       float64 `json:"used_percent"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
+}
+
+// saveDiskHistoryToDB queues per-mountpoint usage for one time point
+func saveDiskHistoryToDB(ts int64, disks []DiskInfo) error {
+	if len(disks) == 0 {
+		return nil
+	}
+	points := make([]DiskHistoryPoint, 0, len(disks))
+	for _, d := range disks {
+		points = append(points, DiskHistoryPoint{
+			Timestamp:         ts,
+			Mountpoint:        d.Mountpoint,
+			Device:            d.Device,
+			Total:             d.Total,
+			Used:              d.Used,
+			Free:              d.Free,
+			UsedPercent:       d.UsedPercent,
+			InodesUsedPercent: d.InodesUsedPercent,
+		})
+	}
+	return queueDBWrite(len(points), points, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare("INSERT INTO disk_history (timestamp, mountpoint, device, total_bytes, used_bytes, free_bytes, used_percent, inodes_used_percent) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, p := range points {
+			if _, err := stmt.Exec(p.Timestamp, p.Mountpoint, p.Device, p.Total, p.Used, p.Free, p.UsedPercent, p.InodesUsedPercent); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// queryDiskHistoryFromDB queries per-mountpoint history (all mountpoints if mountpoint is empty)
+// Points still queued for the next flush are included
+func queryDiskHistoryFromDB(startTime, endTime int64, mountpoint string) ([]DiskHistoryPoint, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	query := "SELECT timestamp, mountpoint, device, total_bytes, used_bytes, free_bytes, used_percent, inodes_used_percent FROM disk_history WHERE timestamp >= ? AND timestamp <= ?"
+	args := []interface{}{startTime, endTime}
+	if mountpoint != "" {
+		query += " AND mountpoint = ?"
+		args = append(args, mountpoint)
+	}
+	query += " ORDER BY timestamp ASC, mountpoint ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []DiskHistoryPoint
+	for rows.Next() {
+		var p DiskHistoryPoint
+		if err := rows.Scan(&p.Timestamp, &p.Mountpoint, &p.Device, &p.Total, &p.Used, &p.Free, &p.UsedPercent, &p.InodesUsedPercent); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	queued := false
+	for _, d := range pendingDBWrites() {
+		points, _ := d.([]DiskHistoryPoint)
+		for _, p := range points {
+			if p.Timestamp >= startTime && p.Timestamp <= endTime && (mountpoint == "" || p.Mountpoint == mountpoint) {
+				result = append(result, p)
+				queued = true
+			}
+		}
+	}
+	if queued {
+		sort.SliceStable(result, func(i, j int) bool {
+			if result[i].Timestamp != result[j].Timestamp {
+				return result[i].Timestamp < result[j].Timestamp
+			}
+			return result[i].Mountpoint < result[j].Mountpoint
+		})
+	}
+	return result, nil
+}
+
+// handleDiskHistory returns per-mountpoint disk history
+// Query params:
+//   - minutes: last N minutes (default: 60)
+//   - start/end: Unix timestamp range (overrides minutes)
+//   - mountpoint: only this mountpoint (default: all)
+func handleDiskHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !storesDiskHistory() {
+		w.WriteHeader(http.StatusNotImplemented)
+		json.NewEncoder(w).Encode(map[string]string{"error": errNoDiskHistory.Error()})
+		return
+	}
+	query := r.URL.Query()
+
+	endTime := time.Now().Unix()
+	var startTime int64
+	if startStr := query.Get("start"); startStr != "" {
+		startTime, _ = strconv.ParseInt(startStr, 10, 64)
+		if endStr := query.Get("end"); endStr != "" {
+			endTime, _ = strconv.ParseInt(endStr, 10, 64)
+		}
+	} else {
+		minutes := 60
+		if m := query.Get("minutes"); m != "" {
+			if v, err := strconv.Atoi(m); err == nil && v > 0 {
+				minutes = v
+			}
+		}
+		startTime = time.Now().Add(-time.Duration(minutes) * time.Minute).Unix()
+	}
+
+	data, err := queryDiskHistoryFromDB(startTime, endTime, query.Get("mountpoint"))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"interval_seconds": int(historyInterval.Seconds()),
+		"start_time":       startTime,
+		"end_time":         endTime,
+		"count":            len(data),
+		"data":             data,
+	})
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Graphite/StatsD defaults
+const (
+	metricPathDefaultTemplate = "servers.{hostname}.{metric}"
+	graphiteDefaultTimeout    = 5 * time.Second
+	graphiteMaxBacklog        = 10000 // Lines kept in memory while Graphite is unreachable
+	emitterQueueSize          = 16
+)
+
+// GraphiteConfig is the "graphite" section of the config file
+type GraphiteConfig struct {
+	Address  string   `json:"address"`  // host:port of the plaintext listener (usually 2003); empty disables
+	Template string   `json:"template"` // Metric path, e.g. servers.{hostname}.{metric}
+	Timeout  Duration `json:"timeout"`
+}
+
+// enabled reports whether metrics are sent to Graphite
+func (c *GraphiteConfig) enabled() bool {
+	return c.Address != ""
+}
+
+// validate checks the Graphite settings
+func (c *GraphiteConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid address %q: %w", c.Address, err)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return validateMetricTemplate(c.Template)
+}
+
+// validateMetricTemplate checks a metric path template
+func validateMetricTemplate(template string) error {
+	if strings.Trim(template, ".") == "" {
+		return fmt.Errorf("template must not be empty")
+	}
+	if strings.ContainsAny(template, " \t\r\n:|") {
+		return fmt.Errorf("template must not contain whitespace, ':' or '|'")
+	}
+	return nil
+}
+
+// metricTemplate returns the template with an explicit {metric} placeholder
+// A template without {metric} is a prefix for the metric names
+func metricTemplate(template string) string {
+	if strings.Contains(template, "{metric}") {
+		return template
+	}
+	return strings.TrimSuffix(template, ".") + ".{metric}"
+}
+
+// metricValue is one named value for the path-based emitters
+type metricValue struct {
+	Name  string // Dotted name below the template, e.g. cpu.total
+	Value float64
+}
+
+var metricPathInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// metricPathSegment makes a name usable as a single dotted path segment
+func metricPathSegment(name string) string {
+	name = strings.Trim(metricPathInvalidChars.ReplaceAllString(name, "_"), "_")
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+// expandMetricPath fills the template placeholders
+func expandMetricPath(template, hostname, metric string) string {
+	return strings.NewReplacer("{hostname}", metricPathSegment(hostname), "{metric}", metric).Replace(metricTemplate(template))
+}
+
+// metricHostname returns the {hostname} value; it comes from the host info cache
+// so the emitters do not depend on the MQTT settings
+func metricHostname() string {
+	hostInfo, err := getCachedHostInfo()
+	if err != nil || hostInfo.Hostname == "" {
+		return "unknown"
+	}
+	return hostInfo.Hostname
+}
+
+// emitterMetrics lists the values sent to Graphite and StatsD
+func emitterMetrics(point HistoryPoint, info *SystemInfo) []metricValue {
+	metrics := []metricValue{
+		{"cpu.total", point.CPUPercent},
+		{"mem.used_percent", point.MemPercent},
+		{"disk.used_percent", point.DiskPercent},
+		{"net.rx_bytes_per_sec", point.NetRxRate},
+		{"net.tx_bytes_per_sec", point.NetTxRate},
+		{"diskio.read_bytes_per_sec", point.DiskReadRate},
+		{"diskio.write_bytes_per_sec", point.DiskWriteRate},
+	}
+	if info == nil {
+		return metrics
+	}
+
+	add := func(name string, value float64) {
+		metrics = append(metrics, metricValue{name, value})
+	}
+	add("uptime_seconds", float64(info.Host.Uptime))
+	for i, v := range info.CPU.UsagePercent {
+		add(fmt.Sprintf("cpu.core.%d", i), v)
+	}
+	add("mem.used_bytes", float64(info.Memory.Used))
+	add("mem.total_bytes", float64(info.Memory.Total))
+	for _, t := range info.Temperature {
+		add("temperature."+metricPathSegment(t.Name), t.Temperature)
+	}
+	for _, d := range info.Disks {
+		seg := "disks." + metricPathSegment(d.Mountpoint)
+		add(seg+".used_percent", d.UsedPercent)
+		add(seg+".free_bytes", float64(d.Free))
+	}
+	for _, n := range info.Network.Interfaces {
+		seg := "network." + metricPathSegment(n.Name)
+		add(seg+".rx_bytes_per_sec", n.RxBytesPerSec)
+		add(seg+".tx_bytes_per_sec", n.TxBytesPerSec)
+	}
+	for _, d := range info.DiskIO.Devices {
+		seg := "diskio.devices." + metricPathSegment(d.Name)
+		add(seg+".read_bytes_per_sec", d.ReadBytesPerSec)
+		add(seg+".write_bytes_per_sec", d.WriteBytesPerSec)
+		add(seg+".util_percent", d.UtilPercent)
+	}
+	return metrics
+}
+
+// formatMetricValue formats a value the way the MQTT per-metric topics do
+func formatMetricValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// Lines for the Graphite sender (nil when disabled)
+var graphiteQueue chan []string
+
+// startGraphiteEmitter starts the Graphite sender
+func startGraphiteEmitter() {
+	cfg := appConfig.Graphite
+	if !cfg.enabled() {
+		return
+	}
+	graphiteQueue = make(chan []string, emitterQueueSize)
+	go runGraphiteSender(cfg)
+	log.Printf("Graphite emitter enabled: %s\n", cfg.Address)
+}
+
+// emitGraphite queues a history point for Graphite (called from collectHistory)
+func emitGraphite(point HistoryPoint, info *SystemInfo) {
+	if graphiteQueue == nil {
+		return
+	}
+	cfg := appConfig.Graphite
+	hostname := metricHostname()
+	ts := strconv.FormatInt(point.Timestamp, 10)
+
+	metrics := emitterMetrics(point, info)
+	lines := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		lines = append(lines, expandMetricPath(cfg.Template, hostname, m.Name)+" "+formatMetricValue(m.Value)+" "+ts+"\n")
+	}
+	select {
+	case graphiteQueue <- lines:
+	default:
+		log.Printf("Graphite: sender is busy, dropping point\n")
+	}
+}
+
+// runGraphiteSender keeps one TCP connection, reconnecting on failure
+// Unsent lines are kept (up to graphiteMaxBacklog) and resent after reconnecting;
+// Graphite stores one value per path and timestamp, so a resent line is harmless
+func runGraphiteSender(cfg GraphiteConfig) {
+	timeout := time.Duration(cfg.Timeout)
+	var conn net.Conn
+	var backlog []string
+	failing := false
+
+	for lines := range graphiteQueue {
+		backlog = append(backlog, lines...)
+		if len(backlog) > graphiteMaxBacklog {
+			backlog = backlog[len(backlog)-graphiteMaxBacklog:]
+		}
+
+		// A write to a connection the server has closed can still succeed, so check first
+		// and retry once on a fresh connection if the write fails anyway
+		if conn != nil && graphiteConnClosed(conn) {
+			conn.Close()
+			conn = nil
+		}
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			if conn == nil {
+				if conn, err = net.DialTimeout("tcp", cfg.Address, timeout); err != nil {
+					conn = nil
+					break
+				}
+			}
+			conn.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err = io.WriteString(conn, strings.Join(backlog, "")); err == nil {
+				break
+			}
+			conn.Close()
+			conn = nil
+		}
+
+		if err != nil {
+			if !failing {
+				log.Printf("Graphite: %v (keeping up to %d lines until it is reachable)\n", err, graphiteMaxBacklog)
+				failing = true
+			}
+			continue
+		}
+		if failing {
+			log.Printf("Graphite: reconnected, sent %d lines\n", len(backlog))
+			failing = false
+		}
+		backlog = backlog[:0]
+	}
+}
+
+// graphiteConnClosed reports whether the server closed the connection
+// Carbon never writes to plaintext clients, so anything but a timeout means it is gone
+func graphiteConnClosed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return true
+}
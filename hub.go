@@ -0,0 +1,719 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Hub defaults
+const (
+	hubDefaultStaleAfter = 2 * time.Minute
+	hubDefaultRetention  = 30 * 24 * time.Hour
+	hubCheckInterval     = 30 * time.Second
+)
+
+// HubConfig is the "hub" section of the config file
+type HubConfig struct {
+	Enabled    bool     `json:"enabled"`
+	StaleAfter Duration `json:"stale_after"` // Hosts without messages for this long are stale
+	Retention  Duration `json:"retention"`   // How long per-host history is kept
+}
+
+// validate checks the hub settings
+func (h *HubConfig) validate() error {
+	if h.StaleAfter < Duration(time.Second) {
+		return fmt.Errorf("stale_after must be at least 1s")
+	}
+	if h.Retention < Duration(time.Hour) {
+		return fmt.Errorf("retention must be at least 1h")
+	}
+	return nil
+}
+
+// AgentPayload is the JSON document agents publish (see mqttJSONPayload)
+type AgentPayload struct {
+	Hostname      string          `json:"hostname"`
+	Timestamp     int64           `json:"timestamp"`
+	CPUPercent    float64         `json:"cpu"`
+	MemPercent    float64         `json:"mem"`
+	DiskPercent   float64         `json:"disk"`
+	NetRxRate     float64         `json:"net_rx"`
+	NetTxRate     float64         `json:"net_tx"`
+	DiskReadRate  float64         `json:"disk_read"`
+	DiskWriteRate float64         `json:"disk_write"`
+	Uptime        uint64          `json:"uptime"`
+	System        json.RawMessage `json:"system,omitempty"`
+}
+
+// HostSummary is one entry of /api/hosts
+type HostSummary struct {
+	ID            string  `json:"id"`
+	FirstSeen     int64   `json:"first_seen"`
+	LastSeen      int64   `json:"last_seen"`      // When the hub last received a message
+	LastTimestamp int64   `json:"last_timestamp"` // Timestamp of the newest data point
+	Stale         bool    `json:"stale"`
+	Availability  string  `json:"availability"` // Last payload on <prefix>/<id>/status
+	CPUPercent    float64 `json:"cpu"`
+	MemPercent    float64 `json:"mem"`
+	DiskPercent   float64 `json:"disk"`
+	NetRxRate     float64 `json:"net_rx"`
+	NetTxRate     float64 `json:"net_tx"`
+	Uptime        uint64  `json:"uptime"`
+}
+
+var (
+	hubClient     mqtt.Client
+	hubMutex      sync.Mutex
+	hubStaleHosts = make(map[string]bool) // Hosts already reported as stale
+)
+
+// hubEnabled reports whether this instance aggregates other agents
+func hubEnabled() bool {
+	return appConfig.Hub.Enabled
+}
+
+// startHub connects the hub subscriber and starts the stale/retention checks
+func startHub() {
+	if !hubEnabled() {
+		return
+	}
+	connectHub()
+
+	go func() {
+		ticker := time.NewTicker(hubCheckInterval)
+		defer ticker.Stop()
+
+		lastPrune := time.Time{}
+		for range ticker.C {
+			checkStaleHosts()
+			if time.Since(lastPrune) >= time.Hour {
+				if err := pruneHostHistory(); err != nil {
+					log.Printf("Hub: failed to prune host history: %v\n", err)
+				}
+				lastPrune = time.Now()
+			}
+		}
+	}()
+	log.Printf("Hub mode enabled (stale after %v)\n", time.Duration(appConfig.Hub.StaleAfter))
+}
+
+// connectHub (re)connects the subscriber using the MQTT broker settings
+func connectHub() {
+	if !hubEnabled() {
+		return
+	}
+	mqttMutex.RLock()
+	config := mqttConfig
+	mqttMutex.RUnlock()
+
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+
+	if hubClient != nil && hubClient.IsConnected() {
+		hubClient.Disconnect(250)
+	}
+
+	tlsConfig, err := buildMQTTTLSConfig(config)
+	if err != nil {
+		log.Printf("Hub: MQTT TLS error: %v\n", err)
+		return
+	}
+
+	dataTopic := config.TopicPrefix + "/+"
+	statusTopic := config.TopicPrefix + "/+/status"
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(getEffectiveClientID() + "-hub").
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("Hub: subscribed to %s on %s\n", dataTopic, config.Broker)
+			c.Subscribe(dataTopic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+				handleHubMessage(msg.Topic(), msg.Payload())
+			})
+			c.Subscribe(statusTopic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+				handleHubStatus(msg.Topic(), msg.Payload())
+			})
+		}).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.Printf("Hub: MQTT connection lost: %v\n", err)
+		})
+	if config.Username != "" {
+		opts.SetUsername(config.Username)
+		opts.SetPassword(config.Password)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	hubClient = mqtt.NewClient(opts)
+	token := hubClient.Connect()
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			log.Printf("Hub: MQTT connection error: %v\n", token.Error())
+		}
+	}()
+}
+
+// disconnectHub closes the hub subscriber
+func disconnectHub() {
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+
+	if hubClient != nil && hubClient.IsConnected() {
+		hubClient.Disconnect(250)
+	}
+}
+
+// hostIDFromTopic returns the client ID level of <prefix>/<id>[/status]
+func hostIDFromTopic(topic string, level int) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) <= level {
+		return ""
+	}
+	return parts[len(parts)-1-level]
+}
+
+// handleHubMessage ingests a metrics payload from <prefix>/<id>
+func handleHubMessage(topic string, payload []byte) {
+	var p AgentPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return
+	}
+	hostID := p.Hostname
+	if hostID == "" {
+		hostID = hostIDFromTopic(topic, 0)
+	}
+	if err := ingestAgentPayload(hostID, p); err != nil {
+		log.Printf("Hub: failed to ingest %s: %v\n", hostID, err)
+	}
+}
+
+// handleHubStatus records the availability payload from <prefix>/<id>/status
+func handleHubStatus(topic string, payload []byte) {
+	hostID := hostIDFromTopic(topic, 1)
+	if hostID == "" {
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return
+	}
+	now := time.Now().Unix()
+	if _, err := db.Exec(`INSERT INTO hosts (id, first_seen, last_seen, last_timestamp, availability) VALUES (?, ?, ?, 0, ?)
+		ON CONFLICT(id) DO UPDATE SET availability = excluded.availability`, hostID, now, now, string(payload)); err != nil {
+		log.Printf("Hub: failed to update availability of %s: %v\n", hostID, err)
+	}
+}
+
+// ingestAgentPayload stores one agent data point and updates the host's latest values
+func ingestAgentPayload(hostID string, p AgentPayload) error {
+	if hostID == "" {
+		return fmt.Errorf("missing hostname")
+	}
+	now := time.Now().Unix()
+	if p.Timestamp == 0 {
+		p.Timestamp = now
+	}
+	system := ""
+	if len(p.System) > 0 {
+		system = string(p.System)
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// Redelivered and retried points are already stored
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO host_history (host_id, timestamp, cpu_percent, mem_percent, disk_percent,
+		net_rx_bytes_per_sec, net_tx_bytes_per_sec, disk_read_bytes_per_sec, disk_write_bytes_per_sec)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hostID, p.Timestamp, p.CPUPercent, p.MemPercent, p.DiskPercent, p.NetRxRate, p.NetTxRate, p.DiskReadRate, p.DiskWriteRate); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Latest values only move forward, so replayed (queued) points do not overwrite newer ones
+	if _, err := tx.Exec(`INSERT INTO hosts (id, first_seen, last_seen, last_timestamp, cpu_percent, mem_percent, disk_percent,
+		net_rx_bytes_per_sec, net_tx_bytes_per_sec, uptime_seconds, system)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			last_seen = excluded.last_seen,
+			last_timestamp = MAX(hosts.last_timestamp, excluded.last_timestamp),
+			cpu_percent = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp THEN excluded.cpu_percent ELSE hosts.cpu_percent END,
+			mem_percent = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp THEN excluded.mem_percent ELSE hosts.mem_percent END,
+			disk_percent = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp THEN excluded.disk_percent ELSE hosts.disk_percent END,
+			net_rx_bytes_per_sec = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp THEN excluded.net_rx_bytes_per_sec ELSE hosts.net_rx_bytes_per_sec END,
+			net_tx_bytes_per_sec = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp THEN excluded.net_tx_bytes_per_sec ELSE hosts.net_tx_bytes_per_sec END,
+			uptime_seconds = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp THEN excluded.uptime_seconds ELSE hosts.uptime_seconds END,
+			system = CASE WHEN excluded.last_timestamp >= hosts.last_timestamp AND excluded.system != '' THEN excluded.system ELSE hosts.system END`,
+		hostID, now, now, p.Timestamp, p.CPUPercent, p.MemPercent, p.DiskPercent, p.NetRxRate, p.NetTxRate, p.Uptime, system); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// scanHostSummary reads a hosts row
+func scanHostSummary(row interface{ Scan(...interface{}) error }, staleBefore int64) (HostSummary, error) {
+	var h HostSummary
+	err := row.Scan(&h.ID, &h.FirstSeen, &h.LastSeen, &h.LastTimestamp, &h.Availability,
+		&h.CPUPercent, &h.MemPercent, &h.DiskPercent, &h.NetRxRate, &h.NetTxRate, &h.Uptime)
+	h.Stale = h.LastSeen < staleBefore
+	return h, err
+}
+
+const hostSummaryColumns = `id, first_seen, last_seen, last_timestamp, availability,
+	cpu_percent, mem_percent, disk_percent, net_rx_bytes_per_sec, net_tx_bytes_per_sec, uptime_seconds`
+
+// staleBefore returns the last_seen cutoff for stale hosts
+func staleBefore() int64 {
+	return time.Now().Add(-time.Duration(appConfig.Hub.StaleAfter)).Unix()
+}
+
+// queryHosts returns all known hosts ordered by ID
+func queryHosts() ([]HostSummary, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	rows, err := db.Query("SELECT " + hostSummaryColumns + " FROM hosts ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cutoff := staleBefore()
+	hosts := []HostSummary{}
+	for rows.Next() {
+		h, err := scanHostSummary(rows, cutoff)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, rows.Err()
+}
+
+// queryHost returns one host and its latest full system payload
+func queryHost(id string) (*HostSummary, json.RawMessage, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, nil, fmt.Errorf("database not initialized")
+	}
+	var system string
+	row := db.QueryRow("SELECT "+hostSummaryColumns+", system FROM hosts WHERE id = ?", id)
+	var h HostSummary
+	err := row.Scan(&h.ID, &h.FirstSeen, &h.LastSeen, &h.LastTimestamp, &h.Availability,
+		&h.CPUPercent, &h.MemPercent, &h.DiskPercent, &h.NetRxRate, &h.NetTxRate, &h.Uptime, &system)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	h.Stale = h.LastSeen < staleBefore()
+	if system == "" {
+		return &h, nil, nil
+	}
+	return &h, json.RawMessage(system), nil
+}
+
+// queryHostHistory returns a host's points in a time range
+func queryHostHistory(id string, startTime, endTime int64) ([]HistoryPoint, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	rows, err := db.Query(`SELECT timestamp, cpu_percent, mem_percent, disk_percent, net_rx_bytes_per_sec, net_tx_bytes_per_sec,
+		disk_read_bytes_per_sec, disk_write_bytes_per_sec FROM host_history
+		WHERE host_id = ? AND timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC`, id, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []HistoryPoint
+	for rows.Next() {
+		var p HistoryPoint
+		if err := rows.Scan(&p.Timestamp, &p.CPUPercent, &p.MemPercent, &p.DiskPercent, &p.NetRxRate, &p.NetTxRate,
+			&p.DiskReadRate, &p.DiskWriteRate); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// deleteHost forgets a host and its history
+func deleteHost(id string) (bool, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+	if _, err := db.Exec("DELETE FROM host_history WHERE host_id = ?", id); err != nil {
+		return false, err
+	}
+	res, err := db.Exec("DELETE FROM hosts WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// pruneHostHistory deletes host history older than the retention period
+func pruneHostHistory() error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	cutoff := time.Now().Add(-time.Duration(appConfig.Hub.Retention)).Unix()
+	_, err := db.Exec("DELETE FROM host_history WHERE timestamp < ?", cutoff)
+	return err
+}
+
+// checkStaleHosts logs hosts that became stale or recovered
+func checkStaleHosts() {
+	hosts, err := queryHosts()
+	if err != nil {
+		return
+	}
+	for _, h := range hosts {
+		if h.Stale && !hubStaleHosts[h.ID] {
+			log.Printf("Hub: host %s is stale (last seen %s)\n", h.ID, time.Unix(h.LastSeen, 0).Format(time.RFC3339))
+		} else if !h.Stale && hubStaleHosts[h.ID] {
+			log.Printf("Hub: host %s is reporting again\n", h.ID)
+		}
+		hubStaleHosts[h.ID] = h.Stale
+	}
+}
+
+// writeHubDisabled responds to hub endpoints when hub mode is off
+func writeHubDisabled(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "hub mode is disabled"})
+}
+
+// handleHosts lists all hosts seen by the hub
+func handleHosts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !hubEnabled() {
+		writeHubDisabled(w)
+		return
+	}
+
+	hosts, err := queryHosts()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	stale := 0
+	for _, h := range hosts {
+		if h.Stale {
+			stale++
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stale_after_seconds": int(time.Duration(appConfig.Hub.StaleAfter).Seconds()),
+		"count":               len(hosts),
+		"stale":               stale,
+		"hosts":               hosts,
+	})
+}
+
+// handleHost serves /api/hosts/{id} (GET, DELETE) and /api/hosts/{id}/history
+// History query params:
+//   - minutes: last N minutes (default: 60)
+//   - start/end: Unix timestamp range (overrides minutes)
+func handleHost(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !hubEnabled() {
+		writeHubDisabled(w)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/hosts/")
+	id, sub, _ := strings.Cut(rest, "/")
+	if id == "" || (sub != "" && sub != "history") {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "not found"})
+		return
+	}
+
+	if sub == "history" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+		query := r.URL.Query()
+		endTime := time.Now().Unix()
+		var startTime int64
+		if startStr := query.Get("start"); startStr != "" {
+			startTime, _ = strconv.ParseInt(startStr, 10, 64)
+			if endStr := query.Get("end"); endStr != "" {
+				endTime, _ = strconv.ParseInt(endStr, 10, 64)
+			}
+		} else {
+			minutes := 60
+			if m := query.Get("minutes"); m != "" {
+				if v, err := strconv.Atoi(m); err == nil && v > 0 {
+					minutes = v
+				}
+			}
+			startTime = time.Now().Add(-time.Duration(minutes) * time.Minute).Unix()
+		}
+
+		data, err := queryHostHistory(id, startTime, endTime)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"host":       id,
+			"start_time": startTime,
+			"end_time":   endTime,
+			"count":      len(data),
+			"data":       data,
+		})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		host, system, err := queryHost(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if host == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "host not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"host":   host,
+			"system": system,
+		})
+
+	case http.MethodDelete:
+		found, err := deleteHost(id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "host not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+	}
+}
+
+// Maximum decoded /api/ingest body size
+const hubMaxIngestBody = 32 << 20
+
+// handleIngest accepts pushed points (a JSON object or array of agent payloads, optionally gzip encoded)
+func handleIngest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !hubEnabled() {
+		writeHubDisabled(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid gzip body: " + err.Error()})
+			return
+		}
+		defer zr.Close()
+		body = zr
+	}
+	data, err := io.ReadAll(io.LimitReader(body, hubMaxIngestBody+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if len(data) > hubMaxIngestBody {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]string{"error": "body too large"})
+		return
+	}
+
+	var points []AgentPayload
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &points)
+	} else {
+		var p AgentPayload
+		err = json.Unmarshal(data, &p)
+		points = append(points, p)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid JSON: " + err.Error()})
+		return
+	}
+	for _, p := range points {
+		if p.Hostname == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "every point needs a hostname"})
+			return
+		}
+	}
+
+	for _, p := range points {
+		if err := ingestAgentPayload(p.Hostname, p); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "accepted": len(points)})
+}
+
+// handleFleetPage serves the fleet overview
+func handleFleetPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(fleetPageHTML))
+}
+
+const fleetPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>Fleet Overview</title>
+<style>
+* { margin: 0; padding: 0; box-sizing: border-box; }
+body { background: #0a0a0a; color: #00ff00; font-family: 'Courier New', monospace; font-size: 14px; padding: 20px; }
+.container { max-width: 1200px; margin: 0 auto; }
+h1 { color: #00ff00; border-bottom: 1px solid #333; padding-bottom: 10px; margin-bottom: 20px; font-size: 18px; }
+.section { background: #111; border: 1px solid #333; margin-bottom: 15px; padding: 15px; border-radius: 4px; }
+.section-title { color: #0af; font-weight: bold; margin-bottom: 10px; display: flex; justify-content: space-between; align-items: center; }
+.stats { color: #666; font-size: 12px; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 8px; text-align: left; border-bottom: 1px solid #222; }
+th { color: #0af; font-weight: bold; background: #1a1a1a; }
+td { color: #0f0; }
+tr:hover { background: #1a1a1a; }
+tr.stale td { color: #555; }
+.bar { display: inline-block; width: 60px; height: 8px; background: #222; margin-right: 6px; vertical-align: middle; }
+.bar span { display: block; height: 100%; background: #0f0; }
+.bar span.warn { background: #ff0; }
+.bar span.crit { background: #f00; }
+.state-online { color: #0f0; }
+.state-stale { color: #f80; }
+.state-offline { color: #f00; }
+.back-link { color: #0af; text-decoration: none; }
+.back-link:hover { text-decoration: underline; }
+.footer { display: flex; justify-content: space-between; align-items: center; margin-top: 15px; color: #444; font-size: 11px; }
+</style>
+</head>
+<body>
+<div class="container">
+<h1>FLEET OVERVIEW</h1>
+<div class="section">
+  <div class="section-title"><span>HOSTS</span><span class="stats" id="summary">Loading...</span></div>
+  <table>
+    <thead><tr><th>Host</th><th>State</th><th>CPU</th><th>Memory</th><th>Disk</th><th>Net RX/TX</th><th>Uptime</th><th>Last Seen</th></tr></thead>
+    <tbody id="hosts"></tbody>
+  </table>
+</div>
+<div class="footer"><a class="back-link" href="/">← Dashboard</a><span id="updated"></span></div>
+</div>
+<script>
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, c => ({'&':'&amp;','<':'&lt;','>':'&gt;','"':'&quot;',"'":'&#39;'}[c]));
+}
+function bar(v) {
+  const cls = v >= 90 ? 'crit' : v >= 70 ? 'warn' : '';
+  return '<span class="bar"><span class="' + cls + '" style="width:' + Math.min(100, v).toFixed(0) + '%"></span></span>' + v.toFixed(1) + '%';
+}
+function rate(b) {
+  if (b >= 1048576) return (b / 1048576).toFixed(1) + 'M';
+  if (b >= 1024) return (b / 1024).toFixed(1) + 'K';
+  return b.toFixed(0) + 'B';
+}
+function uptime(s) {
+  const d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600);
+  return d > 0 ? d + 'd ' + h + 'h' : h + 'h ' + Math.floor(s % 3600 / 60) + 'm';
+}
+function ago(ts) {
+  const s = Math.max(0, Math.floor(Date.now() / 1000 - ts));
+  if (s < 60) return s + 's ago';
+  if (s < 3600) return Math.floor(s / 60) + 'm ago';
+  return Math.floor(s / 3600) + 'h ago';
+}
+function load() {
+  fetch('/api/hosts').then(r => r.json()).then(d => {
+    if (d.error) { document.getElementById('summary').textContent = d.error; return; }
+    document.getElementById('summary').textContent = d.count + ' hosts, ' + d.stale + ' stale';
+    document.getElementById('hosts').innerHTML = d.hosts.map(h => {
+      let state = 'online';
+      if (h.stale) state = 'stale';
+      if (h.availability === 'offline') state = 'offline';
+      return '<tr class="' + (h.stale ? 'stale' : '') + '"><td>' + esc(h.id) + '</td>' +
+        '<td class="state-' + state + '">' + state.toUpperCase() + '</td>' +
+        '<td>' + bar(h.cpu) + '</td><td>' + bar(h.mem) + '</td><td>' + bar(h.disk) + '</td>' +
+        '<td>' + rate(h.net_rx) + ' / ' + rate(h.net_tx) + '</td>' +
+        '<td>' + uptime(h.uptime) + '</td><td>' + ago(h.last_seen) + '</td></tr>';
+    }).join('');
+    document.getElementById('updated').textContent = 'Updated: ' + new Date().toLocaleTimeString();
+  }).catch(() => { document.getElementById('summary').textContent = 'Error loading hosts'; });
+}
+load();
+setInterval(load, 10000);
+</script>
+</body>
+</html>`
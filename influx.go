@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxDB writer defaults
+const (
+	influxDefaultMeasurement = "sysinfo"
+	influxDefaultBatchSize   = 100
+)
+
+// InfluxConfig is the "influx" section of the config file
+// Set database (1.x /write) or org and bucket (2.x/3.x /api/v2/write)
+type InfluxConfig struct {
+	URL                string            `json:"url"` // Base URL, e.g. http://influxdb:8086; empty disables the writer
+	Database           string            `json:"database"`
+	RetentionPolicy    string            `json:"retention_policy"`
+	Username           string            `json:"username"`
+	Password           string            `json:"password"`
+	Org                string            `json:"org"`
+	Bucket             string            `json:"bucket"`
+	Token              string            `json:"token"`
+	Measurement        string            `json:"measurement"`
+	Tags               map[string]string `json:"tags"` // Extra tags added to every line
+	BatchSize          int               `json:"batch_size"`
+	FlushInterval      Duration          `json:"flush_interval"` // 0: write on every history point
+	Gzip               bool              `json:"gzip"`
+	Timeout            Duration          `json:"timeout"`
+	CAFile             string            `json:"ca_file"`
+	InsecureSkipVerify bool              `json:"insecure_skip_verify"`
+	SpoolMaxSize       int               `json:"spool_max_size"` // 0: default, -1: no spool
+	SpoolMaxAge        Duration          `json:"spool_max_age"`
+}
+
+// enabled reports whether points are written to InfluxDB
+func (c *InfluxConfig) enabled() bool {
+	return c.URL != ""
+}
+
+// validate checks the InfluxDB settings
+func (c *InfluxConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http:// or https:// URL")
+	}
+	if c.Bucket == "" && c.Database == "" {
+		return fmt.Errorf("set database (InfluxDB 1.x) or bucket (InfluxDB 2.x)")
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("batch_size must be at least 1")
+	}
+	if c.FlushInterval < 0 || c.Timeout <= 0 || c.SpoolMaxAge < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	return nil
+}
+
+// redacted masks the credentials
+func (c InfluxConfig) redacted() InfluxConfig {
+	if c.Password != "" {
+		c.Password = "***"
+	}
+	if c.Token != "" {
+		c.Token = "***"
+	}
+	return c
+}
+
+// measurement returns the base measurement name
+func (c InfluxConfig) measurement() string {
+	if c.Measurement != "" {
+		return c.Measurement
+	}
+	return influxDefaultMeasurement
+}
+
+// writeURL returns the 1.x or 2.x write endpoint with second precision
+func (c InfluxConfig) writeURL() string {
+	base := strings.TrimSuffix(c.URL, "/")
+	q := url.Values{}
+	q.Set("precision", "s")
+	if c.Bucket != "" {
+		q.Set("org", c.Org)
+		q.Set("bucket", c.Bucket)
+		return base + "/api/v2/write?" + q.Encode()
+	}
+	q.Set("db", c.Database)
+	if c.RetentionPolicy != "" {
+		q.Set("rp", c.RetentionPolicy)
+	}
+	return base + "/write?" + q.Encode()
+}
+
+// spoolLimits returns the effective size and age limits (size 0 means the spool is disabled)
+func (c InfluxConfig) spoolLimits() (int, time.Duration) {
+	return spoolLimits(c.SpoolMaxSize, c.SpoolMaxAge)
+}
+
+var (
+	influxClient *http.Client
+	influxSpool  = newSpool("InfluxDB", "influx_spool")
+)
+
+// startInfluxWriter loads the spool depth and starts the sender
+func startInfluxWriter() {
+	cfg := appConfig.Influx
+	if !cfg.enabled() {
+		return
+	}
+	client, err := newExporterHTTPClient(cfg.CAFile, "", "", cfg.InsecureSkipVerify, time.Duration(cfg.Timeout))
+	if err != nil {
+		log.Printf("InfluxDB: %v\n", err)
+		return
+	}
+	influxClient = client
+
+	influxSpool.load()
+	influxSpool.run(time.Duration(cfg.FlushInterval), cfg.BatchSize, func(payloads [][]byte) *sendError {
+		return writeInfluxBatch(cfg, payloads)
+	})
+	log.Printf("InfluxDB writer enabled: %s\n", cfg.writeURL())
+}
+
+// writeInfluxPoint queues the line protocol of a history point for the sender (called from collectHistory)
+func writeInfluxPoint(point HistoryPoint, info *SystemInfo) {
+	cfg := appConfig.Influx
+	if !cfg.enabled() || influxClient == nil {
+		return
+	}
+	lines := influxLines(cfg.measurement(), getEffectiveClientID(), cfg.Tags, point, info)
+	maxSize, maxAge := cfg.spoolLimits()
+	if err := influxSpool.enqueue(point.Timestamp, lines, maxSize, maxAge); err != nil {
+		log.Printf("InfluxDB: failed to spool point: %v\n", err)
+		return
+	}
+	influxSpool.wake()
+}
+
+// writeInfluxBatch POSTs spooled points (each a block of lines) to the write endpoint
+func writeInfluxBatch(cfg InfluxConfig, payloads [][]byte) *sendError {
+	header := http.Header{}
+	switch {
+	case cfg.Token != "":
+		header.Set("Authorization", "Token "+cfg.Token)
+	case cfg.Username != "":
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(cfg.Username+":"+cfg.Password)))
+	}
+	return postBatch(influxClient, cfg.writeURL(), "text/plain; charset=utf-8", bytes.Join(payloads, nil), cfg.Gzip, header)
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+// influxFloat formats a float field value
+func influxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// influxLine builds one line; tags are sorted as InfluxDB recommends
+func influxLine(buf *bytes.Buffer, measurement string, tags map[string]string, fields []string, ts int64) {
+	buf.WriteString(influxMeasurementEscaper.Replace(measurement))
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteByte(',')
+		buf.WriteString(influxTagEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(influxTagEscaper.Replace(tags[k]))
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(strings.Join(fields, ","))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(ts, 10))
+	buf.WriteByte('\n')
+}
+
+// influxLines converts a history point to line protocol
+// With info, per-sensor, per-disk, per-interface and per-device lines are added
+func influxLines(measurement, host string, extraTags map[string]string, point HistoryPoint, info *SystemInfo) []byte {
+	tags := func(kv ...string) map[string]string {
+		t := make(map[string]string, len(extraTags)+1+len(kv)/2)
+		for k, v := range extraTags {
+			t[k] = v
+		}
+		t["host"] = host
+		for i := 0; i+1 < len(kv); i += 2 {
+			t[kv[i]] = kv[i+1]
+		}
+		return t
+	}
+
+	var buf bytes.Buffer
+	fields := []string{
+		"cpu=" + influxFloat(point.CPUPercent),
+		"mem=" + influxFloat(point.MemPercent),
+		"disk=" + influxFloat(point.DiskPercent),
+		"net_rx=" + influxFloat(point.NetRxRate),
+		"net_tx=" + influxFloat(point.NetTxRate),
+		"disk_read=" + influxFloat(point.DiskReadRate),
+		"disk_write=" + influxFloat(point.DiskWriteRate),
+	}
+	if info != nil {
+		fields = append(fields, "uptime="+strconv.FormatUint(info.Host.Uptime, 10)+"i")
+	}
+	influxLine(&buf, measurement, tags(), fields, point.Timestamp)
+	if info == nil {
+		return buf.Bytes()
+	}
+
+	for _, t := range info.Temperature {
+		influxLine(&buf, measurement+"_temperature", tags("sensor", t.Name),
+			[]string{"value=" + influxFloat(t.Temperature)}, point.Timestamp)
+	}
+	for _, d := range info.Disks {
+		influxLine(&buf, measurement+"_disk", tags("mountpoint", d.Mountpoint, "device", d.Device), []string{
+			"used_percent=" + influxFloat(d.UsedPercent),
+			"total=" + strconv.FormatUint(d.Total, 10) + "i",
+			"free=" + strconv.FormatUint(d.Free, 10) + "i",
+		}, point.Timestamp)
+	}
+	for _, n := range info.Network.Interfaces {
+		influxLine(&buf, measurement+"_net", tags("interface", n.Name), []string{
+			"rx=" + influxFloat(n.RxBytesPerSec),
+			"tx=" + influxFloat(n.TxBytesPerSec),
+		}, point.Timestamp)
+	}
+	for _, d := range info.DiskIO.Devices {
+		influxLine(&buf, measurement+"_diskio", tags("device", d.Name), []string{
+			"read=" + influxFloat(d.ReadBytesPerSec),
+			"write=" + influxFloat(d.WriteBytesPerSec),
+			"util=" + influxFloat(d.UtilPercent),
+		}, point.Timestamp)
+	}
+	return buf.Bytes()
+}
+
+// handleInfluxStatus reports the writer state
+func handleInfluxStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg := appConfig.Influx
+	target := ""
+	if cfg.enabled() {
+		target = cfg.writeURL()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":     cfg.enabled(),
+		"url":         target,
+		"spool_depth": influxSpool.getDepth(),
+		"status":      influxSpool.getStatus(),
+	})
+}
@@ -11,32 +11,33 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/kardianos/service"
 	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/host"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/process"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// History configuration - optimized for low resource usage
-const (
+// History configuration - optimized for low resource usage (overridable via config)
+var (
 	historyInterval = 30 * time.Second // Collect every 30 seconds (reduced from 10s)
 	historyMaxSize  = 120              // 1 hour of data (120 * 30s = 3600s)
 )
 
-// Cache configuration for reducing CPU usage
-const (
-	sysInfoCacheTTL  = 3 * time.Second   // System info cache TTL
-	hostInfoCacheTTL = 5 * time.Minute   // Host info rarely changes
+// Cache configuration for reducing CPU usage (overridable via config)
+var (
+	sysInfoCacheTTL    = 3 * time.Second // System info cache TTL
 	cpuCollectInterval = 2 * time.Second // Background CPU collection interval
 )
 
+const hostInfoCacheTTL = 5 * time.Minute // Host info rarely changes
+
 // Feature flags
 var enableTemperature = true // Set to false to disable temperature monitoring
 
@@ -46,6 +47,10 @@ type HistoryPoint struct {
 	CPUPercent float64 `json:"cpu"`         // CPU average %
 	MemPercent float64 `json:"mem"`         // Memory %
 	DiskPercent float64 `json:"disk"`       // Disk %
+	NetRxRate   float64 `json:"net_rx"`     // Network receive bytes/sec
+	NetTxRate   float64 `json:"net_tx"`     // Network transmit bytes/sec
+	DiskReadRate  float64 `json:"disk_read"`  // Disk read bytes/sec
+	DiskWriteRate float64 `json:"disk_write"` // Disk write bytes/sec
 }
 
 // RingBuffer is a fixed-size circular buffer for history data
@@ -110,6 +115,46 @@ type MQTTConfig struct {
 	Password    string `json:"password"`
 	TopicPrefix string `json:"topic_prefix"`
 	ClientID    string `json:"client_id"`
+
+	// TLS (used with ssl://, mqtts:// and wss:// brokers)
+	CAFile             string   `json:"ca_file"`              // Trust only this CA bundle instead of the system roots
+	ClientCertFile     string   `json:"client_cert_file"`     // Client certificate for mutual TLS
+	ClientKeyFile      string   `json:"client_key_file"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"` // Do not verify the broker certificate
+	ServerName         string   `json:"server_name"`          // SNI / verification name (default: broker host)
+	ALPN               []string `json:"alpn"`
+
+	// Home Assistant MQTT discovery
+	HADiscovery       bool   `json:"ha_discovery"`
+	HADiscoveryPrefix string `json:"ha_discovery_prefix"` // Default: homeassistant
+
+	// Availability (birth message and Last Will)
+	AvailabilityTopic string `json:"availability_topic"` // Default: <topic_prefix>/<client_id>/status
+	PayloadOnline     string `json:"payload_online"`     // Default: online
+	PayloadOffline    string `json:"payload_offline"`    // Default: offline
+
+	// Metric publishing
+	QoS    byte   `json:"qos"`
+	Retain bool   `json:"retain"`
+	Layout string `json:"layout"` // json (default), topics or both
+
+	// Store-and-forward queue for the JSON payload while the broker is unreachable
+	QueueMaxSize int      `json:"queue_max_size"` // Messages kept (0: default 10000, -1: disabled)
+	QueueMaxAge  Duration `json:"queue_max_age"`  // Older messages are dropped (default: 24h)
+
+	// Publish schedule and remote commands on <prefix>/<client_id>/cmd
+	PublishInterval Duration `json:"publish_interval"` // 0: publish on every history tick
+	CommandsEnabled bool     `json:"commands_enabled"`
+	AllowedCommands []string `json:"allowed_commands"` // Default: publish, get_processes
+}
+
+// MQTTAvailability is the resolved availability topic and payloads
+type MQTTAvailability struct {
+	Topic          string `json:"topic"`
+	PayloadOnline  string `json:"payload_online"`
+	PayloadOffline string `json:"payload_offline"`
+	QoS            byte   `json:"qos"`
+	Retain         bool   `json:"retain"`
 }
 
 var (
@@ -117,6 +162,7 @@ var (
 	mqttClient  mqtt.Client
 	mqttMutex   sync.RWMutex
 	mqttConnected bool
+	mqttAvailable MQTTAvailability // Availability settings of the current connection
 )
 
 // getMQTTConfigPath returns the path to the MQTT config file
@@ -166,34 +212,76 @@ func saveMQTTConfigLocked() error {
 }
 
 // getEffectiveClientID returns the client ID to use (custom or hostname)
+// Called for every exported point, so the hostname comes from the host info cache
 func getEffectiveClientID() string {
 	mqttMutex.RLock()
-	defer mqttMutex.RUnlock()
+	clientID := mqttConfig.ClientID
+	mqttMutex.RUnlock()
 
-	if mqttConfig.ClientID != "" {
-		return mqttConfig.ClientID
+	if clientID != "" {
+		return clientID
 	}
-	hostInfo, err := host.Info()
+	hostInfo, err := getCachedHostInfo()
 	if err != nil {
 		return "unknown"
 	}
 	return hostInfo.Hostname
 }
 
+// getMQTTAvailability resolves the availability topic and payloads for a client ID
+// "{client_id}" in a custom topic is replaced with the client ID
+func getMQTTAvailability(cfg MQTTConfig, clientID string) MQTTAvailability {
+	a := MQTTAvailability{
+		Topic:          fmt.Sprintf("%s/%s/status", cfg.TopicPrefix, clientID),
+		PayloadOnline:  "online",
+		PayloadOffline: "offline",
+		QoS:            1,
+		Retain:         true,
+	}
+	if cfg.AvailabilityTopic != "" {
+		a.Topic = strings.ReplaceAll(cfg.AvailabilityTopic, "{client_id}", clientID)
+	}
+	if cfg.PayloadOnline != "" {
+		a.PayloadOnline = cfg.PayloadOnline
+	}
+	if cfg.PayloadOffline != "" {
+		a.PayloadOffline = cfg.PayloadOffline
+	}
+	return a
+}
+
+// disconnectMQTTLocked publishes the offline state and closes the connection (must hold mqttMutex)
+// The broker only sends the Last Will on unexpected disconnects
+func disconnectMQTTLocked() {
+	if mqttClient != nil && mqttClient.IsConnected() {
+		token := mqttClient.Publish(mqttAvailable.Topic, mqttAvailable.QoS, mqttAvailable.Retain, mqttAvailable.PayloadOffline)
+		token.WaitTimeout(2 * time.Second)
+		mqttClient.Disconnect(250)
+	}
+	mqttConnected = false
+}
+
 // connectMQTT establishes connection to MQTT broker
 func connectMQTT() {
 	mqttMutex.Lock()
 	defer mqttMutex.Unlock()
 
+	// Disconnect existing client if any
+	disconnectMQTTLocked()
+
 	if !mqttConfig.Enabled {
-		mqttConnected = false
 		return
 	}
 
-	// Disconnect existing client if any
-	if mqttClient != nil && mqttClient.IsConnected() {
-		mqttClient.Disconnect(250)
+	tlsConfig, err := buildMQTTTLSConfig(mqttConfig)
+	if err != nil {
+		log.Printf("MQTT TLS error: %v\n", err)
+		mqttConnected = false
+		return
 	}
+	mqttBrokerCertMutex.Lock()
+	mqttBrokerCert = nil
+	mqttBrokerCertMutex.Unlock()
 
 	clientID := mqttConfig.ClientID
 	if clientID == "" {
@@ -204,6 +292,8 @@ func connectMQTT() {
 			clientID = "sysinfo-api"
 		}
 	}
+	availability := getMQTTAvailability(mqttConfig, clientID)
+	mqttAvailable = availability
 
 	opts := mqtt.NewClientOptions().
 		AddBroker(mqttConfig.Broker).
@@ -211,11 +301,21 @@ func connectMQTT() {
 		SetAutoReconnect(true).
 		SetConnectRetry(true).
 		SetConnectRetryInterval(10 * time.Second).
+		SetWill(availability.Topic, availability.PayloadOffline, availability.QoS, availability.Retain).
 		SetOnConnectHandler(func(c mqtt.Client) {
 			log.Printf("MQTT connected to %s\n", mqttConfig.Broker)
 			mqttMutex.Lock()
 			mqttConnected = true
+			config := mqttConfig
 			mqttMutex.Unlock()
+			// Birth message replaces the retained Last Will
+			c.Publish(availability.Topic, availability.QoS, availability.Retain, availability.PayloadOnline)
+			// Send points queued while the broker was unreachable
+			kickMQTTQueue()
+			// Retained discovery configs are republished on every (re)connect
+			go publishHADiscovery(c, config, clientID)
+			// Clean sessions drop subscriptions, so subscribe again on every connect
+			go subscribeMQTTCommands(c, config, clientID)
 		}).
 		SetConnectionLostHandler(func(c mqtt.Client, err error) {
 			log.Printf("MQTT connection lost: %v\n", err)
@@ -228,6 +328,9 @@ func connectMQTT() {
 		opts.SetUsername(mqttConfig.Username)
 		opts.SetPassword(mqttConfig.Password)
 	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
 
 	mqttClient = mqtt.NewClient(opts)
 	token := mqttClient.Connect()
@@ -242,57 +345,72 @@ func connectMQTT() {
 func disconnectMQTT() {
 	mqttMutex.Lock()
 	defer mqttMutex.Unlock()
-
-	if mqttClient != nil && mqttClient.IsConnected() {
-		mqttClient.Disconnect(250)
-	}
-	mqttConnected = false
+	disconnectMQTTLocked()
 }
 
 // publishMetrics publishes current metrics to MQTT
-func publishMetrics(point HistoryPoint) {
+func publishMetrics(point HistoryPoint, info *SystemInfo) {
 	mqttMutex.RLock()
-	enabled := mqttConfig.Enabled
-	topicPrefix := mqttConfig.TopicPrefix
+	config := mqttConfig
+	client := mqttClient
 	mqttMutex.RUnlock()
 
-	if !enabled || mqttClient == nil || !mqttClient.IsConnected() {
+	if !config.Enabled || client == nil {
 		return
 	}
+	connected := client.IsConnectionOpen()
 
 	clientID := getEffectiveClientID()
-	topic := fmt.Sprintf("%s/%s", topicPrefix, clientID)
+	topic := fmt.Sprintf("%s/%s", config.TopicPrefix, clientID)
 
-	// Get system uptime
-	var uptime uint64
-	if hostInfo, err := getCachedHostInfo(); err == nil {
-		uptime = hostInfo.Uptime
-	}
-
-	payload := map[string]interface{}{
-		"hostname": clientID,
-		"cpu":      point.CPUPercent,
-		"mem":      point.MemPercent,
-		"disk":     point.DiskPercent,
-		"uptime":   uptime,
+	var messages []mqttMessage
+	if config.publishesJSON() {
+		data, err := json.Marshal(mqttJSONPayload(clientID, point, info))
+		if err != nil {
+			log.Printf("MQTT marshal error: %v\n", err)
+			return
+		}
+		msg := mqttMessage{Topic: topic, Payload: data}
+		// While disconnected or still draining, points go through the queue to keep them in order
+		if !connected || getMQTTQueueDepth() > 0 {
+			if err := enqueueMQTTMessage(config, point.Timestamp, msg); err != nil {
+				log.Printf("MQTT queue error: %v\n", err)
+			}
+			if connected {
+				kickMQTTQueue()
+			}
+		} else {
+			messages = append(messages, msg)
+		}
 	}
-
-	data, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("MQTT marshal error: %v\n", err)
+	// Plain per-metric values carry no timestamp, so they are only sent live
+	if !connected {
 		return
 	}
+	if config.publishesTopics() {
+		messages = append(messages, mqttMetricMessages(topic, point, info)...)
+	}
 
-	token := mqttClient.Publish(topic, 0, false, data)
-	go func() {
-		if token.Wait() && token.Error() != nil {
-			log.Printf("MQTT publish error: %v\n", token.Error())
-		}
-	}()
+	for _, m := range messages {
+		token := client.Publish(m.Topic, config.QoS, config.Retain, m.Payload)
+		go func() {
+			if token.Wait() && token.Error() != nil {
+				log.Printf("MQTT publish error: %v\n", token.Error())
+			}
+		}()
+	}
 }
 
 // getDataDir returns the directory for storing data files
 func getDataDir() string {
+	if appConfig.DataDir != "" {
+		return appConfig.DataDir
+	}
+	return defaultDataDir()
+}
+
+// defaultDataDir returns the data directory used when none is configured
+func defaultDataDir() string {
 	// Try to use the directory where the executable is located
 	exe, err := os.Executable()
 	if err == nil {
@@ -302,53 +420,72 @@ func getDataDir() string {
 	return "."
 }
 
-// initDB initializes the SQLite database
+// initDB opens the SQLite database and applies pending schema migrations
 func initDB() error {
-	dbPath := filepath.Join(getDataDir(), "sysinfo_history.db")
-	var err error
-	db, err = sql.Open("sqlite3", dbPath)
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+	return openDBLocked()
+}
+
+// openDBLocked opens and migrates the database unless it is already open (dbMutex must be held)
+// Features that only need it occasionally call this on first use
+func openDBLocked() error {
+	if db != nil {
+		return nil
+	}
+	dbPath := getDBPath()
+	conn, err := sql.Open("sqlite3", sqliteDSN(dbPath))
 	if err != nil {
 		return fmt.Errorf("failed to open database: %w", err)
 	}
-
-	// Create table if not exists
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS history (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		timestamp INTEGER NOT NULL,
-		cpu_percent REAL NOT NULL,
-		mem_percent REAL NOT NULL,
-		disk_percent REAL NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_history_timestamp ON history(timestamp);
-	`
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+	if err := migrateDB(conn, dbPath); err != nil {
+		conn.Close()
+		return err
 	}
+	db = conn
 
-	log.Printf("Database initialized: %s\n", dbPath)
+	log.Printf("Database initialized: %s (schema version %d)\n", dbPath, latestSchemaVersion())
 	return nil
 }
 
-// saveHistoryToDB saves a history point to the database
+// openExistingDBLocked opens the database only if the file already exists, so data left by a
+// previous run is picked up without creating an empty database (dbMutex must be held)
+func openExistingDBLocked() error {
+	if db != nil {
+		return nil
+	}
+	if _, err := os.Stat(getDBPath()); os.IsNotExist(err) {
+		return nil
+	}
+	return openDBLocked()
+}
+
+// saveHistoryToDB queues a history point for the next batched commit
 func saveHistoryToDB(p HistoryPoint) error {
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	return queueDBWrite(1, p, func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT INTO history (timestamp, cpu_percent, mem_percent, disk_percent, net_rx_bytes_per_sec, net_tx_bytes_per_sec, disk_read_bytes_per_sec, disk_write_bytes_per_sec) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			p.Timestamp, p.CPUPercent, p.MemPercent, p.DiskPercent, p.NetRxRate, p.NetTxRate, p.DiskReadRate, p.DiskWriteRate,
+		)
+		return err
+	})
+}
 
-	if db == nil {
-		return fmt.Errorf("database not initialized")
+// pendingHistoryPoints returns the history points queued for the next flush, oldest first
+// (call it with dbMutex held to keep it consistent with a query of the history table)
+func pendingHistoryPoints() []HistoryPoint {
+	var result []HistoryPoint
+	for _, d := range pendingDBWrites() {
+		if p, ok := d.(HistoryPoint); ok {
+			result = append(result, p)
+		}
 	}
-
-	_, err := db.Exec(
-		"INSERT INTO history (timestamp, cpu_percent, mem_percent, disk_percent) VALUES (?, ?, ?, ?)",
-		p.Timestamp, p.CPUPercent, p.MemPercent, p.DiskPercent,
-	)
-	return err
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	return result
 }
 
 // queryHistoryFromDB queries history from database with time range
+// Points still queued for the next flush are included
 func queryHistoryFromDB(startTime, endTime int64) ([]HistoryPoint, error) {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
@@ -358,7 +495,7 @@ func queryHistoryFromDB(startTime, endTime int64) ([]HistoryPoint, error) {
 	}
 
 	rows, err := db.Query(
-		"SELECT timestamp, cpu_percent, mem_percent, disk_percent FROM history WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC",
+		"SELECT timestamp, cpu_percent, mem_percent, disk_percent, net_rx_bytes_per_sec, net_tx_bytes_per_sec, disk_read_bytes_per_sec, disk_write_bytes_per_sec FROM history WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp ASC",
 		startTime, endTime,
 	)
 	if err != nil {
@@ -369,15 +506,29 @@ func queryHistoryFromDB(startTime, endTime int64) ([]HistoryPoint, error) {
 	var result []HistoryPoint
 	for rows.Next() {
 		var p HistoryPoint
-		if err := rows.Scan(&p.Timestamp, &p.CPUPercent, &p.MemPercent, &p.DiskPercent); err != nil {
+		if err := rows.Scan(&p.Timestamp, &p.CPUPercent, &p.MemPercent, &p.DiskPercent, &p.NetRxRate, &p.NetTxRate, &p.DiskReadRate, &p.DiskWriteRate); err != nil {
 			return nil, err
 		}
 		result = append(result, p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	queued := false
+	for _, p := range pendingHistoryPoints() {
+		if p.Timestamp >= startTime && p.Timestamp <= endTime {
+			result = append(result, p)
+			queued = true
+		}
+	}
+	if queued {
+		sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	}
 	return result, nil
 }
 
-// getHistoryStats returns statistics about stored history
+// getHistoryStats returns statistics about stored history, including points queued for the next flush
 func getHistoryStats() (minTime, maxTime int64, count int64, err error) {
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
@@ -387,6 +538,18 @@ func getHistoryStats() (minTime, maxTime int64, count int64, err error) {
 	}
 
 	err = db.QueryRow("SELECT COALESCE(MIN(timestamp), 0), COALESCE(MAX(timestamp), 0), COUNT(*) FROM history").Scan(&minTime, &maxTime, &count)
+	if err != nil {
+		return
+	}
+	for _, p := range pendingHistoryPoints() {
+		if count == 0 || p.Timestamp < minTime {
+			minTime = p.Timestamp
+		}
+		if p.Timestamp > maxTime {
+			maxTime = p.Timestamp
+		}
+		count++
+	}
 	return
 }
 
@@ -539,15 +702,23 @@ h1 { color: #00ff00; border-bottom: 1px solid #333; padding-bottom: 10px; margin
       <div class="mqtt-row"><label>Client ID</label><input type="text" id="mqtt-client-id" placeholder="(auto: hostname)"></div>
       <div class="mqtt-row"><label>Username</label><input type="text" id="mqtt-username" placeholder="(optional)"></div>
       <div class="mqtt-row"><label>Password</label><input type="password" id="mqtt-password" placeholder="(optional)"></div>
+      <div class="mqtt-row"><label>CA File</label><input type="text" id="mqtt-ca-file" placeholder="(ssl:// only, default: system roots)"></div>
+      <div class="mqtt-row"><label>Client Cert</label><input type="text" id="mqtt-client-cert" placeholder="(optional, PEM path)"></div>
+      <div class="mqtt-row"><label>Client Key</label><input type="text" id="mqtt-client-key" placeholder="(optional, PEM path)"></div>
+      <div class="mqtt-row"><label>Server Name</label><input type="text" id="mqtt-server-name" placeholder="(SNI, default: broker host)"></div>
+      <div class="mqtt-row"><label>ALPN</label><input type="text" id="mqtt-alpn" placeholder="(optional, comma-separated)"></div>
+      <div class="mqtt-topic" id="mqtt-tls-status"></div>
       <div class="mqtt-topic">Topic: <span id="mqtt-topic-preview">sysinfo/...</span></div>
       <div class="mqtt-actions">
         <label class="mqtt-toggle"><input type="checkbox" id="mqtt-enabled"><span class="slider"></span><span>Enable MQTT</span></label>
+        <label class="mqtt-toggle"><input type="checkbox" id="mqtt-insecure"><span class="slider"></span><span>Skip TLS Verify</span></label>
+        <label class="mqtt-toggle"><input type="checkbox" id="mqtt-ha-discovery"><span class="slider"></span><span>Home Assistant</span></label>
         <button class="mqtt-btn" id="mqtt-save">Save Settings</button>
       </div>
     </div>
   </div>
 </div>
-<div class="update-time"><a href="/processes" style="color:#0af;text-decoration:none">View Processes →</a> | Refresh: 5s | History: 120 points (30s interval)</div>
+<div class="update-time"><a href="/processes" style="color:#0af;text-decoration:none">View Processes →</a> | <a href="/fleet" style="color:#0af;text-decoration:none">Fleet →</a> | Refresh: live | History: {history_max_size} points ({history_interval} interval)</div>
 </div>
 <script>
 const MAX_POINTS = 60;
@@ -621,8 +792,7 @@ function initMQTTState() {
   }
 }
 
-function update() {
-  fetch('/api/system').then(r => r.json()).then(d => {
+function render(d) {
     let cpuAvg = d.cpu.usage_percent.reduce((a,b) => a+b, 0) / d.cpu.usage_percent.length;
 
     // Update history
@@ -668,6 +838,17 @@ function update() {
         '<div class="metric-card-detail">' + formatBytes(d.disk.used_bytes) + ' / ' + formatBytes(d.disk.total_bytes) + '</div>' +
       '</div>';
 
+    // Network card (conditional)
+    if (d.network && d.network.interfaces && d.network.interfaces.length > 0) {
+      metricCards +=
+        '<div class="metric-card">' +
+          '<div class="metric-card-title">NETWORK</div>' +
+          '<div class="metric-card-percent" style="color:#0af">↓ ' + formatBytes(d.network.rx_bytes_per_sec) + '/s</div>' +
+          '<div class="metric-card-percent" style="color:#0af">↑ ' + formatBytes(d.network.tx_bytes_per_sec) + '/s</div>' +
+          '<div class="metric-card-detail">' + d.network.interfaces.filter(i => i.name !== 'lo').length + ' interfaces</div>' +
+        '</div>';
+    }
+
     // Temperature card (conditional)
     if (d.temperature && d.temperature.length > 0) {
       let maxTemp = Math.max(...d.temperature.map(t => t.temperature));
@@ -692,11 +873,21 @@ function update() {
       '</div>';
     }).join('');
     document.getElementById('core-cards').innerHTML = coreCards;
+}
 
-  }).catch(e => { document.getElementById('metric-cards').innerHTML = '<div style="color:red">Error: ' + e + '</div>'; });
+// Live updates via Server-Sent Events (EventSource reconnects automatically)
+function connectStream() {
+  const es = new EventSource('/api/stream?sections=host,cpu,memory,disk,temperature,network');
+  es.addEventListener('snapshot', e => {
+    const d = JSON.parse(e.data);
+    if (!systemHostname) { systemHostname = d.host.hostname; updateTopicPreview(); }
+    render(d);
+  });
+  es.onerror = () => {
+    document.getElementById('metric-cards').innerHTML = '<div style="color:red">Stream disconnected, reconnecting...</div>';
+  };
 }
-update();
-setInterval(update, 5000);
+connectStream();
 
 // MQTT Configuration
 let mqttConfig = {};
@@ -710,6 +901,13 @@ function loadMQTTConfig() {
     document.getElementById('mqtt-username').value = cfg.username || '';
     document.getElementById('mqtt-password').value = cfg.password === '***' ? '***' : '';
     document.getElementById('mqtt-enabled').checked = cfg.enabled;
+    document.getElementById('mqtt-ca-file').value = cfg.ca_file || '';
+    document.getElementById('mqtt-client-cert').value = cfg.client_cert_file || '';
+    document.getElementById('mqtt-client-key').value = cfg.client_key_file || '';
+    document.getElementById('mqtt-server-name').value = cfg.server_name || '';
+    document.getElementById('mqtt-alpn').value = (cfg.alpn || []).join(',');
+    document.getElementById('mqtt-insecure').checked = cfg.insecure_skip_verify;
+    document.getElementById('mqtt-ha-discovery').checked = cfg.ha_discovery;
     updateTopicPreview();
   });
 }
@@ -727,9 +925,24 @@ function loadMQTTStatus() {
       text.textContent = 'Disabled';
     }
     document.getElementById('mqtt-topic-preview').textContent = st.topic;
+    document.getElementById('mqtt-tls-status').textContent = formatTLSStatus(st.tls);
   });
 }
 
+function formatTLSStatus(t) {
+  if (!t || !t.enabled) return '';
+  const parts = [];
+  const describe = (label, c) => {
+    if (!c) return;
+    if (c.error) { parts.push(label + ': ' + c.error); return; }
+    parts.push(label + ': ' + c.subject + (c.expired ? ' (EXPIRED)' : ' (' + c.days_remaining + 'd left)'));
+  };
+  describe('Broker', t.broker_cert);
+  describe('Client', t.client_cert);
+  describe('CA', t.ca);
+  return 'TLS' + (t.insecure_skip_verify ? ' (unverified)' : '') + (parts.length ? ' | ' + parts.join(' | ') : '');
+}
+
 function updateTopicPreview() {
   const clientId = document.getElementById('mqtt-client-id').value || systemHostname || '(hostname)';
   const prefix = 'sysinfo';
@@ -747,29 +960,33 @@ function saveMQTTConfig() {
     client_id: document.getElementById('mqtt-client-id').value,
     username: document.getElementById('mqtt-username').value,
     password: document.getElementById('mqtt-password').value,
-    topic_prefix: 'sysinfo'
+    topic_prefix: 'sysinfo',
+    ca_file: document.getElementById('mqtt-ca-file').value,
+    client_cert_file: document.getElementById('mqtt-client-cert').value,
+    client_key_file: document.getElementById('mqtt-client-key').value,
+    server_name: document.getElementById('mqtt-server-name').value,
+    alpn: document.getElementById('mqtt-alpn').value.split(',').map(s => s.trim()).filter(s => s),
+    insecure_skip_verify: document.getElementById('mqtt-insecure').checked,
+    ha_discovery: document.getElementById('mqtt-ha-discovery').checked
   };
 
   fetch('/api/mqtt/config', {
     method: 'POST',
     headers: {'Content-Type': 'application/json'},
     body: JSON.stringify(cfg)
-  }).then(r => r.json()).then(() => {
+  }).then(r => r.json().then(res => {
+    if (!r.ok) throw new Error(res.error);
+  })).then(() => {
     btn.textContent = 'Saved!';
     setTimeout(() => { btn.textContent = 'Save Settings'; btn.disabled = false; }, 1500);
     setTimeout(loadMQTTStatus, 1000);
   }).catch(e => {
     btn.textContent = 'Error!';
+    btn.title = e.message;
     setTimeout(() => { btn.textContent = 'Save Settings'; btn.disabled = false; }, 1500);
   });
 }
 
-// Get system hostname for topic preview
-fetch('/api/system').then(r => r.json()).then(d => {
-  systemHostname = d.host.hostname;
-  updateTopicPreview();
-});
-
 document.getElementById('mqtt-save').addEventListener('click', saveMQTTConfig);
 document.getElementById('mqtt-client-id').addEventListener('input', updateTopicPreview);
 
@@ -785,8 +1002,11 @@ type SystemInfo struct {
 	Host        HostInfo      `json:"host"`
 	CPU         CPUInfo       `json:"cpu"`
 	Memory      MemoryInfo    `json:"memory"`
-	Disk        DiskInfo      `json:"disk"`
+	Disk        DiskInfo      `json:"disk"`  // Primary disk ("/" or "C:"), kept for compatibility
+	Disks       []DiskInfo    `json:"disks"` // All mounted partitions passing the disk filter
 	Temperature []TempInfo    `json:"temperature"`
+	Network     NetworkInfo   `json:"network"`
+	DiskIO      DiskIOInfo    `json:"disk_io"`
 }
 
 type TempInfo struct {
@@ -815,10 +1035,17 @@ type MemoryInfo struct {
 }
 
 type DiskInfo struct {
-	Total       uint64  `json:"total_bytes"`
-	Used        uint64  `json:"used_bytes"`
-	Free        uint64  `json:"free_bytes"`
-	UsedPercent float64 `json:"used_percent"`
+	Mountpoint        string  `json:"mountpoint"`
+	Device            string  `json:"device,omitempty"`
+	Fstype            string  `json:"fstype,omitempty"`
+	Total             uint64  `json:"total_bytes"`
+	Used              uint64  `json:"used_bytes"`
+	Free              uint64  `json:"free_bytes"`
+	UsedPercent       float64 `json:"used_percent"`
+	InodesTotal       uint64  `json:"inodes_total"`
+	InodesUsed        uint64  `json:"inodes_used"`
+	InodesFree        uint64  `json:"inodes_free"`
+	InodesUsedPercent float64 `json:"inodes_used_percent"`
 }
 
 // ProcessInfo represents information about a single process
@@ -851,9 +1078,12 @@ var (
 
 // System info cache to avoid repeated gopsutil calls
 var (
-	sysInfoCache      *SystemInfo
-	sysInfoCacheTime  time.Time
-	sysInfoCacheMutex sync.RWMutex
+	sysInfoCache        *SystemInfo
+	sysInfoCacheTime    time.Time
+	sysInfoCacheMutex   sync.RWMutex
+	sysInfoCollectMutex sync.Mutex // Serializes collections so concurrent misses share one
+	sysInfoRefreshing   bool       // Guarded by sysInfoRefreshMutex
+	sysInfoRefreshMutex sync.Mutex
 )
 
 // Host info cache (rarely changes)
@@ -1009,6 +1239,9 @@ func startCPUCollector() {
 			cpuPercentCacheMutex.Lock()
 			cpuPercentCache = percent
 			cpuPercentCacheMutex.Unlock()
+
+			// Push the new sample to live stream subscribers
+			publishStream(nil)
 		}
 	}()
 }
@@ -1037,11 +1270,7 @@ func getSystemInfo() (*SystemInfo, error) {
 		return nil, err
 	}
 
-	diskPath := "/"
-	if hostInfo.OS == "windows" {
-		diskPath = "C:"
-	}
-	diskInfo, err := disk.Usage(diskPath)
+	primaryDisk, disks, err := getDiskInfo(hostInfo.OS)
 	if err != nil {
 		return nil, err
 	}
@@ -1078,32 +1307,26 @@ func getSystemInfo() (*SystemInfo, error) {
 			Free:        memInfo.Free,
 			UsedPercent: memInfo.UsedPercent,
 		},
-		Disk: DiskInfo{
-			Total:       diskInfo.Total,
-			Used:        diskInfo.Used,
-			Free:        diskInfo.Free,
-			UsedPercent: diskInfo.UsedPercent,
-		},
+		Disk:        primaryDisk,
+		Disks:       disks,
 		Temperature: temps,
+		Network:     getCachedNetworkInfo(),
+		DiskIO:      getCachedDiskIOInfo(),
 	}, nil
 }
 
 // getCachedSystemInfo returns cached system info to reduce CPU usage
 func getCachedSystemInfo() (*SystemInfo, error) {
-	sysInfoCacheMutex.RLock()
-	if sysInfoCache != nil && time.Since(sysInfoCacheTime) < sysInfoCacheTTL {
-		info := sysInfoCache
-		sysInfoCacheMutex.RUnlock()
+	if info := freshSystemInfo(); info != nil {
 		return info, nil
 	}
-	sysInfoCacheMutex.RUnlock()
 
-	sysInfoCacheMutex.Lock()
-	defer sysInfoCacheMutex.Unlock()
+	sysInfoCollectMutex.Lock()
+	defer sysInfoCollectMutex.Unlock()
 
-	// Double-check after acquiring write lock
-	if sysInfoCache != nil && time.Since(sysInfoCacheTime) < sysInfoCacheTTL {
-		return sysInfoCache, nil
+	// Double-check after waiting for a collection in progress
+	if info := freshSystemInfo(); info != nil {
+		return info, nil
 	}
 
 	info, err := getSystemInfo()
@@ -1111,11 +1334,57 @@ func getCachedSystemInfo() (*SystemInfo, error) {
 		return nil, err
 	}
 
+	sysInfoCacheMutex.Lock()
 	sysInfoCache = info
 	sysInfoCacheTime = time.Now()
+	sysInfoCacheMutex.Unlock()
 	return info, nil
 }
 
+// freshSystemInfo returns the cached system info if it is younger than the TTL
+func freshSystemInfo() *SystemInfo {
+	sysInfoCacheMutex.RLock()
+	defer sysInfoCacheMutex.RUnlock()
+	if sysInfoCache != nil && time.Since(sysInfoCacheTime) < sysInfoCacheTTL {
+		return sysInfoCache
+	}
+	return nil
+}
+
+// getLatestSystemInfo returns the last collected system info without blocking, even if stale
+// A stale cache is refreshed in the background; ok is false until the first collection finishes
+func getLatestSystemInfo() (*SystemInfo, bool) {
+	sysInfoCacheMutex.RLock()
+	info, age := sysInfoCache, time.Since(sysInfoCacheTime)
+	sysInfoCacheMutex.RUnlock()
+
+	if info == nil || age >= sysInfoCacheTTL {
+		refreshSystemInfo()
+	}
+	return info, info != nil
+}
+
+// refreshSystemInfo repopulates the system info cache on a background goroutine
+// Only one refresh runs at a time; further calls while it runs are no-ops
+func refreshSystemInfo() {
+	sysInfoRefreshMutex.Lock()
+	if sysInfoRefreshing {
+		sysInfoRefreshMutex.Unlock()
+		return
+	}
+	sysInfoRefreshing = true
+	sysInfoRefreshMutex.Unlock()
+
+	go func() {
+		if _, err := getCachedSystemInfo(); err != nil {
+			log.Printf("Warning: Failed to refresh system info: %v\n", err)
+		}
+		sysInfoRefreshMutex.Lock()
+		sysInfoRefreshing = false
+		sysInfoRefreshMutex.Unlock()
+	}()
+}
+
 func handleSystemInfo(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	info, err := getCachedSystemInfo()
@@ -1146,9 +1415,19 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var data []HistoryPoint
+	var rollups []RollupPoint // Rollup buckets when resolution is not raw; data then holds their averages
 	var startTime, endTime int64
 	var useDB bool
 
+	// Explicit resolution: raw, 5m, 1h or 1d (default: picked from the range)
+	step := query.Get("step")
+	if step != "" && step != "raw" && findRollupTier(step) == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "step must be raw, 5m, 1h or 1d"})
+		return
+	}
+
 	// Check if time range is specified
 	if startStr := query.Get("start"); startStr != "" {
 		startTime, _ = strconv.ParseInt(startStr, 10, 64)
@@ -1168,18 +1447,39 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 		endTime = time.Now().Unix()
 		startTime = time.Now().Add(-time.Duration(minutes) * time.Minute).Unix()
 
-		// Use memory buffer for recent data (<=60 min), DB for longer periods
-		if minutes <= 60 {
+		// Use memory buffer for recent raw data (<=60 min), DB for longer periods
+		if minutes <= 60 && (step == "" || step == "raw") {
 			data = historyBuffer.GetSince(startTime)
 		} else {
 			useDB = true
 		}
 	}
 
-	// Query from database if needed
+	// Query from database if needed, from a rollup table for long ranges
+	resolution := "raw"
+	intervalSeconds := int(historyInterval.Seconds())
 	if useDB {
+		var tier *rollupTier
+		switch step {
+		case "":
+			tier = pickHistoryTier(startTime, endTime)
+		case "raw":
+		default:
+			tier = findRollupTier(step)
+		}
+
 		var err error
-		data, err = queryHistoryFromDB(startTime, endTime)
+		if tier == nil {
+			data, err = historyStore.QueryRange(startTime, endTime)
+		} else {
+			rollups, err = historyStore.QueryRollups(*tier, startTime, endTime)
+			data = make([]HistoryPoint, 0, len(rollups))
+			for i := range rollups {
+				data = append(data, rollups[i].average())
+			}
+			resolution = tier.Name
+			intervalSeconds = int(tier.Step)
+		}
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -1194,32 +1494,63 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=sysinfo_history_%d_%d.csv", startTime, endTime))
 
 		writer := csv.NewWriter(w)
-		// Write header
-		writer.Write([]string{"timestamp", "datetime", "cpu_percent", "mem_percent", "disk_percent"})
+		// Write header (rollup resolutions add the other bucket statistics)
+		header := []string{"timestamp", "datetime", "cpu_percent", "mem_percent", "disk_percent", "net_rx_bytes_per_sec", "net_tx_bytes_per_sec", "disk_read_bytes_per_sec", "disk_write_bytes_per_sec"}
+		if resolution != "raw" {
+			header = append(header, rollupCSVHeader()...)
+		}
+		writer.Write(header)
 
 		// Write data
-		for _, p := range data {
+		for i, p := range data {
 			t := time.Unix(p.Timestamp, 0)
-			writer.Write([]string{
+			record := []string{
 				strconv.FormatInt(p.Timestamp, 10),
 				t.Format("2006-01-02 15:04:05"),
 				fmt.Sprintf("%.2f", p.CPUPercent),
 				fmt.Sprintf("%.2f", p.MemPercent),
 				fmt.Sprintf("%.2f", p.DiskPercent),
-			})
+				fmt.Sprintf("%.2f", p.NetRxRate),
+				fmt.Sprintf("%.2f", p.NetTxRate),
+				fmt.Sprintf("%.2f", p.DiskReadRate),
+				fmt.Sprintf("%.2f", p.DiskWriteRate),
+			}
+			if resolution != "raw" {
+				record = append(record, rollups[i].rollupCSVRecord()...)
+			}
+			writer.Write(record)
 		}
 		writer.Flush()
 		return
 	}
 
+	// Return InfluxDB line protocol (second precision, for backfilling)
+	if format == "influx" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		host := getEffectiveClientID()
+		for _, p := range data {
+			w.Write(influxLines(appConfig.Influx.measurement(), host, appConfig.Influx.Tags, p, nil))
+		}
+		return
+	}
+
 	// Return JSON format (default)
+	var points interface{} = data
+	if resolution != "raw" {
+		objs := make([]map[string]interface{}, 0, len(rollups))
+		for i := range rollups {
+			objs = append(objs, rollups[i].historyJSON())
+		}
+		points = objs
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"interval_seconds": int(historyInterval.Seconds()),
+		"interval_seconds": intervalSeconds,
+		"resolution":       resolution,
 		"start_time":       startTime,
 		"end_time":         endTime,
 		"count":            len(data),
-		"data":             data,
+		"data":             points,
 	})
 }
 
@@ -1227,7 +1558,8 @@ func handleHistory(w http.ResponseWriter, r *http.Request) {
 func handleHistoryStats(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	minTime, maxTime, count, err := getHistoryStats()
+	writes := getDBWriteStats()
+	stats, err := historyStore.Stats()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
@@ -1235,6 +1567,7 @@ func handleHistoryStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Calculate time range
+	minTime, maxTime, count := stats.MinTime, stats.MaxTime, stats.Count
 	var durationHours float64
 	var minTimeStr, maxTimeStr string
 	if count > 0 {
@@ -1243,7 +1576,22 @@ func handleHistoryStats(w http.ResponseWriter, r *http.Request) {
 		maxTimeStr = time.Unix(maxTime, 0).Format("2006-01-02 15:04:05")
 	}
 
+	// Rollup tables (only stores that keep them)
+	var rollups map[string]interface{}
+	if stats.Rollups != nil {
+		rollups = make(map[string]interface{})
+		for name, ts := range stats.Rollups {
+			rollups[name] = map[string]interface{}{
+				"records":         ts.Count,
+				"min_timestamp":   ts.MinTime,
+				"max_timestamp":   ts.MaxTime,
+				"retention_hours": appConfig.Retention.forTier(name).Hours(),
+			}
+		}
+	}
+
 	json.NewEncoder(w).Encode(map[string]interface{}{
+		"store":           historyStore.Name(),
 		"total_records":   count,
 		"min_timestamp":   minTime,
 		"max_timestamp":   maxTime,
@@ -1251,6 +1599,9 @@ func handleHistoryStats(w http.ResponseWriter, r *http.Request) {
 		"max_datetime":    maxTimeStr,
 		"duration_hours":  durationHours,
 		"interval_seconds": int(historyInterval.Seconds()),
+		"retention_hours": appConfig.Retention.forTier("raw").Hours(),
+		"rollups":         rollups,
+		"writes":          writes,
 	})
 }
 
@@ -1261,14 +1612,7 @@ func handleMQTTConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		mqttMutex.RLock()
-		config := MQTTConfig{
-			Enabled:     mqttConfig.Enabled,
-			Broker:      mqttConfig.Broker,
-			Username:    mqttConfig.Username,
-			Password:    "",
-			TopicPrefix: mqttConfig.TopicPrefix,
-			ClientID:    mqttConfig.ClientID,
-		}
+		config := mqttConfig
 		// Mask password if set
 		if mqttConfig.Password != "" {
 			config.Password = "***"
@@ -1277,25 +1621,50 @@ func handleMQTTConfig(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(config)
 
 	case http.MethodPost:
-		var newConfig MQTTConfig
+		// Fields missing from the request keep their current values
+		mqttMutex.RLock()
+		newConfig := mqttConfig
+		mqttMutex.RUnlock()
+		currentPassword := newConfig.Password
 		if err := json.NewDecoder(r.Body).Decode(&newConfig); err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 			return
 		}
-
-		mqttMutex.Lock()
-		mqttConfig.Enabled = newConfig.Enabled
-		mqttConfig.Broker = newConfig.Broker
-		mqttConfig.Username = newConfig.Username
 		// Only update password if not masked
-		if newConfig.Password != "***" && newConfig.Password != "" {
-			mqttConfig.Password = newConfig.Password
-		} else if newConfig.Password == "" {
-			mqttConfig.Password = ""
+		if newConfig.Password == "***" {
+			newConfig.Password = currentPassword
+		}
+		if err := newConfig.validateCommands(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if err := newConfig.validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if _, err := buildMQTTTLSConfig(newConfig); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
 		}
-		mqttConfig.TopicPrefix = newConfig.TopicPrefix
-		mqttConfig.ClientID = newConfig.ClientID
+
+		// Remove Home Assistant sensors that would otherwise be left behind
+		mqttMutex.RLock()
+		oldConfig := mqttConfig
+		client := mqttClient
+		mqttMutex.RUnlock()
+		oldClientID := getEffectiveClientID()
+		if oldConfig.HADiscovery && (!newConfig.Enabled || !newConfig.HADiscovery ||
+			newConfig.ClientID != oldConfig.ClientID || newConfig.TopicPrefix != oldConfig.TopicPrefix ||
+			haDiscoveryPrefix(newConfig) != haDiscoveryPrefix(oldConfig)) {
+			clearHADiscovery(client, oldConfig, oldClientID)
+		}
+
+		mqttMutex.Lock()
+		mqttConfig = newConfig
 		mqttMutex.Unlock()
 
 		if err := saveMQTTConfig(); err != nil {
@@ -1305,7 +1674,9 @@ func handleMQTTConfig(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Reconnect with new settings
+		notifyMQTTPublishInterval()
 		go connectMQTT()
+		go connectHub()
 
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 
@@ -1320,6 +1691,7 @@ func handleMQTTStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	mqttMutex.RLock()
+	config := mqttConfig
 	enabled := mqttConfig.Enabled
 	broker := mqttConfig.Broker
 	topicPrefix := mqttConfig.TopicPrefix
@@ -1339,11 +1711,15 @@ func handleMQTTStatus(w http.ResponseWriter, r *http.Request) {
 	topic := fmt.Sprintf("%s/%s", topicPrefix, clientID)
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"enabled":   enabled,
-		"connected": connected,
-		"status":    status,
-		"broker":    broker,
-		"topic":     topic,
+		"enabled":      enabled,
+		"connected":    connected,
+		"status":       status,
+		"broker":       broker,
+		"topic":        topic,
+		"tls":          mqttTLSStatus(config),
+		"availability": getMQTTAvailability(config, clientID),
+		"queue_depth":  getMQTTQueueDepth(),
+		"commands":     mqttCommandStatus(config, clientID),
 	})
 }
 
@@ -1534,6 +1910,29 @@ func handleProcessesPage(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(processesPageHTML))
 }
 
+// newHistoryPoint summarizes a system snapshot as a history point
+func newHistoryPoint(info *SystemInfo) HistoryPoint {
+	// Calculate CPU average
+	var cpuAvg float64
+	if len(info.CPU.UsagePercent) > 0 {
+		for _, v := range info.CPU.UsagePercent {
+			cpuAvg += v
+		}
+		cpuAvg /= float64(len(info.CPU.UsagePercent))
+	}
+
+	return HistoryPoint{
+		Timestamp:     time.Now().Unix(),
+		CPUPercent:    cpuAvg,
+		MemPercent:    info.Memory.UsedPercent,
+		DiskPercent:   info.Disk.UsedPercent,
+		NetRxRate:     info.Network.RxBytesPerSec,
+		NetTxRate:     info.Network.TxBytesPerSec,
+		DiskReadRate:  info.DiskIO.ReadBytesPerSec,
+		DiskWriteRate: info.DiskIO.WriteBytesPerSec,
+	}
+}
+
 // collectHistory runs in background to collect system metrics
 func collectHistory() {
 	ticker := time.NewTicker(historyInterval)
@@ -1545,38 +1944,52 @@ func collectHistory() {
 			continue
 		}
 
-		// Calculate CPU average
-		var cpuAvg float64
-		if len(info.CPU.UsagePercent) > 0 {
-			for _, v := range info.CPU.UsagePercent {
-				cpuAvg += v
+		point := newHistoryPoint(info)
+
+		// Save to memory buffer (for fast recent queries)
+		historyBuffer.Push(point)
+
+		// Save to the history store (for persistent long-term storage)
+		if err := historyStore.WritePoint(point); err != nil {
+			log.Printf("Failed to save history: %v\n", err)
+		}
+		if storesDiskHistory() {
+			if err := saveDiskHistoryToDB(point.Timestamp, info.Disks); err != nil {
+				log.Printf("Failed to save disk history to DB: %v\n", err)
+			}
+			if err := saveDiskIOHistoryToDB(point.Timestamp, info.DiskIO.Devices); err != nil {
+				log.Printf("Failed to save disk I/O history to DB: %v\n", err)
 			}
-			cpuAvg /= float64(len(info.CPU.UsagePercent))
 		}
 
-		point := HistoryPoint{
-			Timestamp:   time.Now().Unix(),
-			CPUPercent:  cpuAvg,
-			MemPercent:  info.Memory.UsedPercent,
-			DiskPercent: info.Disk.UsedPercent,
+		// Publish to MQTT if enabled (unless it has its own publish interval)
+		if mqttPublishInterval() == 0 {
+			publishMetrics(point, info)
 		}
 
-		// Save to memory buffer (for fast recent queries)
-		historyBuffer.Push(point)
+		// Spool for the HTTP push exporter and InfluxDB writer if configured
+		pushPoint(point, info)
+		writeInfluxPoint(point, info)
 
-		// Save to database (for persistent long-term storage)
-		if err := saveHistoryToDB(point); err != nil {
-			log.Printf("Failed to save history to DB: %v\n", err)
-		}
+		// Send to Graphite and StatsD if configured
+		emitGraphite(point, info)
+		emitStatsD(point, info)
+
+		// Evaluate alert rules against the new sample
+		evaluateAlerts(point, info)
 
-		// Publish to MQTT if enabled
-		publishMetrics(point)
+		// Push the new history point to live stream subscribers
+		publishStream(&point)
 	}
 }
 
 func handleDashboard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Write([]byte(dashboardHTML))
+	page := strings.NewReplacer(
+		"{history_max_size}", strconv.Itoa(historyMaxSize),
+		"{history_interval}", historyInterval.String(),
+	).Replace(dashboardHTML)
+	w.Write([]byte(page))
 }
 
 // Service wrapper for Windows service support
@@ -1588,45 +2001,158 @@ func (p *program) Start(s service.Service) error {
 }
 
 func (p *program) run() {
-	// Initialize database for persistent history storage
-	if err := initDB(); err != nil {
-		log.Printf("Warning: Failed to initialize database: %v\n", err)
-		log.Println("History will only be stored in memory (max 1 hour)")
+	if err := openHistoryStore(); err != nil {
+		log.Printf("Warning: Failed to open %s history store: %v\n", appConfig.HistoryStore, err)
+	}
+	// The SQLite history store and the hub keep their data in the database; otherwise only the
+	// MQTT queue and the exporter spools use it, and open it once they have something to keep
+	if storesDiskHistory() || hubEnabled() {
+		if err := initDB(); err != nil {
+			log.Printf("Warning: Failed to initialize database: %v\n", err)
+			log.Println("History will only be stored in memory (max 1 hour)")
+		}
 	}
 
 	// Load MQTT configuration and connect if enabled
+	// Outbound queue must be ready before the first MQTT connect
+	startMQTTQueue()
+	startMQTTPublisher()
 	if err := loadMQTTConfig(); err != nil {
 		log.Printf("Warning: Failed to load MQTT config: %v\n", err)
 	} else {
 		connectMQTT()
 	}
 
+	// Hub mode subscribes to all agents on the same broker
+	startHub()
+
+	// HTTP push exporter for sites without MQTT access
+	startPushExporter()
+
+	// InfluxDB line protocol writer
+	startInfluxWriter()
+
+	// OpenTelemetry metrics exporter
+	startOTLPExporter()
+
+	// Graphite plaintext and StatsD emitters
+	startGraphiteEmitter()
+	startStatsDEmitter()
+
+	// Load alert rules and webhooks
+	if err := loadAlertConfig(); err != nil {
+		log.Printf("Warning: Failed to load alert config: %v\n", err)
+	}
+
+	// Start the live stream publisher before the collectors feed it
+	startStreamPublisher()
+
 	// Start background CPU collector for accurate measurements
 	startCPUCollector()
 	log.Printf("CPU collector started (interval: %v)\n", cpuCollectInterval)
 
+	// Warm the system info cache so the first scrape has data
+	refreshSystemInfo()
+
+	// Start background network collector for interface rates
+	startNetworkCollector()
+	log.Printf("Network collector started (interval: %v)\n", netCollectInterval)
+
+	// Start background disk I/O collector for throughput and latency
+	startDiskIOCollector()
+	log.Printf("Disk I/O collector started (interval: %v)\n", diskIOCollectInterval)
+
 	// Start history collector in background
 	go collectHistory()
 
+	// Commit history inserts in batches to spare flash storage
+	startDBWriter()
+
+	// Downsample history into 5m/1h/1d tables and apply retention
+	startHistoryMaintenance()
+
 	http.HandleFunc("/", handleDashboard)
 	http.HandleFunc("/api/system", handleSystemInfo)
+	http.HandleFunc("/api/stream", handleStream)
 	http.HandleFunc("/api/history", handleHistory)
 	http.HandleFunc("/api/history/stats", handleHistoryStats)
+	http.HandleFunc("/api/history/aggregate", handleHistoryAggregate)
+	http.HandleFunc("/api/history/disks", handleDiskHistory)
+	http.HandleFunc("/api/history/diskio", handleDiskIOHistory)
+	http.HandleFunc("/api/disk/io", handleDiskIO)
 	http.HandleFunc("/api/mqtt/config", handleMQTTConfig)
 	http.HandleFunc("/api/mqtt/status", handleMQTTStatus)
 	http.HandleFunc("/processes", handleProcessesPage)
 	http.HandleFunc("/api/processes", handleProcessesAPI)
+	http.HandleFunc("/api/config", handleConfig)
+	http.HandleFunc("/api/alerts", handleAlerts)
+	http.HandleFunc("/api/alerts/rules", handleAlertRules)
+	http.HandleFunc("/api/alerts/rules/", handleAlertRule)
+	http.HandleFunc("/api/alerts/webhooks", handleAlertWebhooks)
+	http.HandleFunc("/metrics", handleMetrics)
 	http.HandleFunc("/health", handleHealth)
-	log.Println("Server starting on :8088...")
+	http.HandleFunc("/fleet", handleFleetPage)
+	http.HandleFunc("/api/hosts", handleHosts)
+	http.HandleFunc("/api/hosts/", handleHost)
+	http.HandleFunc("/api/ingest", handleIngest)
+	http.HandleFunc("/api/push/status", handlePushStatus)
+	http.HandleFunc("/api/influx/status", handleInfluxStatus)
+	http.HandleFunc("/api/otlp/status", handleOTLPStatus)
+	if configPath != "" {
+		log.Printf("Config loaded from %s\n", configPath)
+	}
+	log.Printf("Server starting on %s...\n", appConfig.Listen)
 	log.Printf("History: collecting every %v, memory buffer %d points, persistent storage enabled\n", historyInterval, historyMaxSize)
-	http.ListenAndServe(":8088", nil)
+	if appConfig.Auth.Enabled {
+		log.Printf("Authentication enabled (%d tokens, %d users)\n", len(appConfig.Auth.Tokens), len(appConfig.Auth.Users))
+	}
+
+	server := &http.Server{
+		Addr:    appConfig.Listen,
+		Handler: authMiddleware(appConfig.Auth, http.DefaultServeMux),
+	}
+	if !appConfig.TLS.enabled() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("Server error: %v\n", err)
+		}
+		return
+	}
+
+	reloader, err := newCertReloader(appConfig.TLS)
+	if err != nil {
+		log.Printf("TLS setup failed: %v\n", err)
+		return
+	}
+	go reloader.watch()
+	server.TLSConfig = reloader.tlsConfig()
+	if appConfig.TLS.ClientCAFile != "" {
+		log.Printf("HTTPS enabled with client certificate verification (%s)\n", appConfig.TLS.ClientCAFile)
+	} else {
+		log.Printf("HTTPS enabled (%s)\n", appConfig.TLS.CertFile)
+	}
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		log.Printf("Server error: %v\n", err)
+	}
 }
 
 func (p *program) Stop(s service.Service) error {
 	// Disconnect MQTT
 	disconnectMQTT()
+	disconnectHub()
+
+	// Keep points the exporters have not sent yet for the next run
+	if err := pushSpool.spill(nil); err != nil {
+		log.Printf("Push: failed to spool points: %v\n", err)
+	}
+	if err := influxSpool.spill(nil); err != nil {
+		log.Printf("InfluxDB: failed to spool points: %v\n", err)
+	}
 
-	// Close database connection
+	// Flush buffered writes, then close the history store and database connection
+	closeDBWrites()
+	if err := historyStore.Close(); err != nil {
+		log.Printf("Failed to close history store: %v\n", err)
+	}
 	if db != nil {
 		db.Close()
 	}
@@ -1634,6 +2160,28 @@ func (p *program) Stop(s service.Service) error {
 }
 
 func main() {
+	// Offline subcommands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "token", "user":
+			if err := runAuthCommand(os.Args[1], os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "migrate":
+			if err := runMigrateCommand(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	cfg, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	applyConfig(cfg)
+
 	svcConfig := &service.Config{
 		Name:        "SysinfoAPI",
 		DisplayName: "System Monitor API",
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Prometheus exposition content types
+const (
+	promTextContentType    = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// metricLabel is a single name="value" pair attached to a sample
+type metricLabel struct {
+	Name  string
+	Value string
+}
+
+// metricsWriter renders metric families in Prometheus text or OpenMetrics format
+type metricsWriter struct {
+	w           io.Writer
+	openMetrics bool
+}
+
+// family writes the HELP and TYPE header for a metric family
+func (mw *metricsWriter) family(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n", name, escapeMetricHelp(help))
+	fmt.Fprintf(mw.w, "# TYPE %s %s\n", name, typ)
+}
+
+// sample writes a single sample line
+func (mw *metricsWriter) sample(name string, value float64, labels ...metricLabel) {
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 0 {
+		sb.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteString(l.Name)
+			sb.WriteString(`="`)
+			sb.WriteString(escapeMetricLabel(l.Value))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	sb.WriteByte('\n')
+	io.WriteString(mw.w, sb.String())
+}
+
+// counterFamily writes the header for a counter family and returns the sample name
+// (OpenMetrics declares counters without the _total suffix)
+func (mw *metricsWriter) counterFamily(base, help string) string {
+	if mw.openMetrics {
+		mw.family(base, "counter", help)
+	} else {
+		mw.family(base+"_total", "counter", help)
+	}
+	return base + "_total"
+}
+
+// infoFamily writes the header for an info family and returns the sample name
+// (OpenMetrics has a native info type declared without the _info suffix)
+func (mw *metricsWriter) infoFamily(base, help string) string {
+	if mw.openMetrics {
+		mw.family(base, "info", help)
+	} else {
+		mw.family(base+"_info", "gauge", help)
+	}
+	return base + "_info"
+}
+
+// gauge writes a complete single-sample gauge family
+func (mw *metricsWriter) gauge(name, help string, value float64, labels ...metricLabel) {
+	mw.family(name, "gauge", help)
+	mw.sample(name, value, labels...)
+}
+
+// finish terminates the exposition (OpenMetrics requires a trailing EOF marker)
+func (mw *metricsWriter) finish() {
+	if mw.openMetrics {
+		io.WriteString(mw.w, "# EOF\n")
+	}
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var metricHelpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeMetricLabel(s string) string { return metricLabelEscaper.Replace(s) }
+func escapeMetricHelp(s string) string  { return metricHelpEscaper.Replace(s) }
+
+// boolToFloat converts a boolean state to a 0/1 gauge value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// wantsOpenMetrics reports whether the scraper negotiated the OpenMetrics format
+func wantsOpenMetrics(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+}
+
+// writeSystemMetrics renders the cached system info as metric families
+func writeSystemMetrics(mw *metricsWriter, info *SystemInfo, mqttEnabled, mqttUp bool) {
+	name := mw.infoFamily("sysinfo_host", "Host information, value is always 1")
+	mw.sample(name, 1,
+		metricLabel{"hostname", info.Host.Hostname},
+		metricLabel{"os", info.Host.OS},
+		metricLabel{"platform", info.Host.Platform},
+	)
+	mw.gauge("sysinfo_uptime_seconds", "Host uptime in seconds", float64(info.Host.Uptime))
+
+	mw.gauge("sysinfo_cpu_cores", "Number of CPU cores", float64(info.CPU.Cores))
+	mw.family("sysinfo_cpu_usage_percent", "gauge", "CPU usage per core in percent")
+	for i, p := range info.CPU.UsagePercent {
+		mw.sample("sysinfo_cpu_usage_percent", p, metricLabel{"core", strconv.Itoa(i)})
+	}
+
+	mw.gauge("sysinfo_memory_total_bytes", "Total physical memory in bytes", float64(info.Memory.Total))
+	mw.gauge("sysinfo_memory_used_bytes", "Used physical memory in bytes", float64(info.Memory.Used))
+	mw.gauge("sysinfo_memory_free_bytes", "Free physical memory in bytes", float64(info.Memory.Free))
+	mw.gauge("sysinfo_memory_used_percent", "Used physical memory in percent", info.Memory.UsedPercent)
+
+	mw.gauge("sysinfo_disk_total_bytes", "Total disk space in bytes", float64(info.Disk.Total))
+	mw.gauge("sysinfo_disk_used_bytes", "Used disk space in bytes", float64(info.Disk.Used))
+	mw.gauge("sysinfo_disk_free_bytes", "Free disk space in bytes", float64(info.Disk.Free))
+	mw.gauge("sysinfo_disk_used_percent", "Used disk space in percent", info.Disk.UsedPercent)
+
+	if len(info.Disks) > 0 {
+		filesystemFamily := func(name, help string, value func(d DiskInfo) float64) {
+			mw.family(name, "gauge", help)
+			for _, d := range info.Disks {
+				mw.sample(name, value(d),
+					metricLabel{"mountpoint", d.Mountpoint},
+					metricLabel{"device", d.Device},
+					metricLabel{"fstype", d.Fstype},
+				)
+			}
+		}
+		filesystemFamily("sysinfo_filesystem_size_bytes", "Filesystem size in bytes", func(d DiskInfo) float64 { return float64(d.Total) })
+		filesystemFamily("sysinfo_filesystem_used_bytes", "Filesystem used space in bytes", func(d DiskInfo) float64 { return float64(d.Used) })
+		filesystemFamily("sysinfo_filesystem_free_bytes", "Filesystem free space in bytes", func(d DiskInfo) float64 { return float64(d.Free) })
+		filesystemFamily("sysinfo_filesystem_inodes", "Filesystem inode count", func(d DiskInfo) float64 { return float64(d.InodesTotal) })
+		filesystemFamily("sysinfo_filesystem_inodes_free", "Filesystem free inodes", func(d DiskInfo) float64 { return float64(d.InodesFree) })
+	}
+
+	if len(info.Network.Interfaces) > 0 {
+		ifaceCounter := func(base, help string, value func(n NetworkInterfaceInfo) uint64) {
+			name := mw.counterFamily(base, help)
+			for _, n := range info.Network.Interfaces {
+				mw.sample(name, float64(value(n)), metricLabel{"interface", n.Name})
+			}
+		}
+		ifaceGauge := func(name, help string, value func(n NetworkInterfaceInfo) float64) {
+			mw.family(name, "gauge", help)
+			for _, n := range info.Network.Interfaces {
+				mw.sample(name, value(n), metricLabel{"interface", n.Name})
+			}
+		}
+		ifaceCounter("sysinfo_network_receive_bytes", "Bytes received per interface", func(n NetworkInterfaceInfo) uint64 { return n.RxBytesTotal })
+		ifaceCounter("sysinfo_network_transmit_bytes", "Bytes transmitted per interface", func(n NetworkInterfaceInfo) uint64 { return n.TxBytesTotal })
+		ifaceCounter("sysinfo_network_receive_errors", "Receive errors per interface", func(n NetworkInterfaceInfo) uint64 { return n.RxErrorsTotal })
+		ifaceCounter("sysinfo_network_transmit_errors", "Transmit errors per interface", func(n NetworkInterfaceInfo) uint64 { return n.TxErrorsTotal })
+		ifaceCounter("sysinfo_network_receive_drops", "Dropped incoming packets per interface", func(n NetworkInterfaceInfo) uint64 { return n.RxDropsTotal })
+		ifaceCounter("sysinfo_network_transmit_drops", "Dropped outgoing packets per interface", func(n NetworkInterfaceInfo) uint64 { return n.TxDropsTotal })
+		ifaceGauge("sysinfo_network_receive_bytes_per_second", "Receive rate per interface in bytes/sec", func(n NetworkInterfaceInfo) float64 { return n.RxBytesPerSec })
+		ifaceGauge("sysinfo_network_transmit_bytes_per_second", "Transmit rate per interface in bytes/sec", func(n NetworkInterfaceInfo) float64 { return n.TxBytesPerSec })
+	}
+
+	if len(info.DiskIO.Devices) > 0 {
+		deviceCounter := func(base, help string, value func(d DiskIODeviceInfo) uint64) {
+			name := mw.counterFamily(base, help)
+			for _, d := range info.DiskIO.Devices {
+				mw.sample(name, float64(value(d)), metricLabel{"device", d.Name})
+			}
+		}
+		deviceGauge := func(name, help string, value func(d DiskIODeviceInfo) float64) {
+			mw.family(name, "gauge", help)
+			for _, d := range info.DiskIO.Devices {
+				mw.sample(name, value(d), metricLabel{"device", d.Name})
+			}
+		}
+		deviceCounter("sysinfo_disk_read_bytes", "Bytes read per block device", func(d DiskIODeviceInfo) uint64 { return d.ReadBytesTotal })
+		deviceCounter("sysinfo_disk_written_bytes", "Bytes written per block device", func(d DiskIODeviceInfo) uint64 { return d.WriteBytesTotal })
+		deviceGauge("sysinfo_disk_iops", "Completed reads and writes per second", func(d DiskIODeviceInfo) float64 { return d.ReadIOPS + d.WriteIOPS })
+		deviceGauge("sysinfo_disk_await_milliseconds", "Average time per completed I/O in milliseconds", func(d DiskIODeviceInfo) float64 { return d.AwaitMs })
+		deviceGauge("sysinfo_disk_utilization_percent", "Share of time the device was busy in percent", func(d DiskIODeviceInfo) float64 { return d.UtilPercent })
+	}
+
+	if len(info.Temperature) > 0 {
+		mw.family("sysinfo_temperature_celsius", "gauge", "Sensor temperature in degrees Celsius")
+		for _, t := range info.Temperature {
+			mw.sample("sysinfo_temperature_celsius", t.Temperature, metricLabel{"sensor", t.Name})
+		}
+	}
+
+	mw.gauge("sysinfo_mqtt_enabled", "Whether MQTT publishing is enabled", boolToFloat(mqttEnabled))
+	mw.gauge("sysinfo_mqtt_connected", "Whether the MQTT client is connected to the broker", boolToFloat(mqttUp))
+}
+
+// handleMetrics serves metrics in Prometheus text format (or OpenMetrics if requested)
+// Only cached data is used so a scrape never blocks on gopsutil
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	info, ok := getLatestSystemInfo()
+	if !ok {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "system info not collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	mqttMutex.RLock()
+	mqttEnabled := mqttConfig.Enabled
+	mqttUp := mqttConnected
+	mqttMutex.RUnlock()
+
+	mw := &metricsWriter{w: w, openMetrics: wantsOpenMetrics(r)}
+	if mw.openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", promTextContentType)
+	}
+
+	writeSystemMetrics(mw, info, mqttEnabled, mqttUp)
+	mw.finish()
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// migration is one step of the database schema
+// Migrations run in version order, each in its own transaction, and are recorded in schema_version.
+// Databases created before versioning start at version 0, so every step must tolerate objects that
+// already exist (CREATE ... IF NOT EXISTS, addColumn)
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+}
+
+// migrations lists every schema change; append new ones, never edit applied ones
+var migrations = []migration{
+	{1, "create history table", execMigration(`
+	CREATE TABLE IF NOT EXISTS history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		cpu_percent REAL NOT NULL,
+		mem_percent REAL NOT NULL,
+		disk_percent REAL NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_history_timestamp ON history(timestamp);
+	`)},
+	{2, "add per-mountpoint disk history", execMigration(`
+	CREATE TABLE IF NOT EXISTS disk_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		mountpoint TEXT NOT NULL,
+		device TEXT NOT NULL,
+		total_bytes INTEGER NOT NULL,
+		used_bytes INTEGER NOT NULL,
+		free_bytes INTEGER NOT NULL,
+		used_percent REAL NOT NULL,
+		inodes_used_percent REAL NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_disk_history_timestamp ON disk_history(timestamp);
+	`)},
+	{3, "add network rates to history", func(tx *sql.Tx) error {
+		if err := addColumn(tx, "history", "net_rx_bytes_per_sec", "REAL NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumn(tx, "history", "net_tx_bytes_per_sec", "REAL NOT NULL DEFAULT 0")
+	}},
+	{4, "add disk I/O history", func(tx *sql.Tx) error {
+		if err := execMigration(`
+		CREATE TABLE IF NOT EXISTS disk_io_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			device TEXT NOT NULL,
+			read_bytes_per_sec REAL NOT NULL,
+			write_bytes_per_sec REAL NOT NULL,
+			read_iops REAL NOT NULL,
+			write_iops REAL NOT NULL,
+			await_ms REAL NOT NULL,
+			util_percent REAL NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_disk_io_history_timestamp ON disk_io_history(timestamp);
+		`)(tx); err != nil {
+			return err
+		}
+		if err := addColumn(tx, "history", "disk_read_bytes_per_sec", "REAL NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+		return addColumn(tx, "history", "disk_write_bytes_per_sec", "REAL NOT NULL DEFAULT 0")
+	}},
+	{5, "add MQTT offline queue", execMigration(`
+	CREATE TABLE IF NOT EXISTS mqtt_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		topic TEXT NOT NULL,
+		payload BLOB NOT NULL,
+		qos INTEGER NOT NULL,
+		retain INTEGER NOT NULL
+	);
+	`)},
+	{6, "add hub host tables", execMigration(`
+	CREATE TABLE IF NOT EXISTS hosts (
+		id TEXT PRIMARY KEY,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		last_timestamp INTEGER NOT NULL,
+		availability TEXT NOT NULL DEFAULT '',
+		cpu_percent REAL NOT NULL DEFAULT 0,
+		mem_percent REAL NOT NULL DEFAULT 0,
+		disk_percent REAL NOT NULL DEFAULT 0,
+		net_rx_bytes_per_sec REAL NOT NULL DEFAULT 0,
+		net_tx_bytes_per_sec REAL NOT NULL DEFAULT 0,
+		uptime_seconds INTEGER NOT NULL DEFAULT 0,
+		system TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE IF NOT EXISTS host_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host_id TEXT NOT NULL,
+		timestamp INTEGER NOT NULL,
+		cpu_percent REAL NOT NULL,
+		mem_percent REAL NOT NULL,
+		disk_percent REAL NOT NULL,
+		net_rx_bytes_per_sec REAL NOT NULL,
+		net_tx_bytes_per_sec REAL NOT NULL,
+		disk_read_bytes_per_sec REAL NOT NULL,
+		disk_write_bytes_per_sec REAL NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_host_history_host_timestamp ON host_history(host_id, timestamp);
+	`)},
+	{7, "add exporter spools", execMigration(`
+	CREATE TABLE IF NOT EXISTS push_spool (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		payload BLOB NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS influx_spool (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		payload BLOB NOT NULL
+	);
+	`)},
+	{8, "add history rollup tables", execMigration(`
+	CREATE TABLE IF NOT EXISTS history_5m (
+		bucket INTEGER PRIMARY KEY,
+		samples INTEGER NOT NULL,
+		cpu_percent_min REAL NOT NULL,
+		cpu_percent_avg REAL NOT NULL,
+		cpu_percent_max REAL NOT NULL,
+		cpu_percent_p95 REAL NOT NULL,
+		mem_percent_min REAL NOT NULL,
+		mem_percent_avg REAL NOT NULL,
+		mem_percent_max REAL NOT NULL,
+		mem_percent_p95 REAL NOT NULL,
+		disk_percent_min REAL NOT NULL,
+		disk_percent_avg REAL NOT NULL,
+		disk_percent_max REAL NOT NULL,
+		disk_percent_p95 REAL NOT NULL,
+		net_rx_bytes_per_sec_min REAL NOT NULL,
+		net_rx_bytes_per_sec_avg REAL NOT NULL,
+		net_rx_bytes_per_sec_max REAL NOT NULL,
+		net_rx_bytes_per_sec_p95 REAL NOT NULL,
+		net_tx_bytes_per_sec_min REAL NOT NULL,
+		net_tx_bytes_per_sec_avg REAL NOT NULL,
+		net_tx_bytes_per_sec_max REAL NOT NULL,
+		net_tx_bytes_per_sec_p95 REAL NOT NULL,
+		disk_read_bytes_per_sec_min REAL NOT NULL,
+		disk_read_bytes_per_sec_avg REAL NOT NULL,
+		disk_read_bytes_per_sec_max REAL NOT NULL,
+		disk_read_bytes_per_sec_p95 REAL NOT NULL,
+		disk_write_bytes_per_sec_min REAL NOT NULL,
+		disk_write_bytes_per_sec_avg REAL NOT NULL,
+		disk_write_bytes_per_sec_max REAL NOT NULL,
+		disk_write_bytes_per_sec_p95 REAL NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS history_1h (
+		bucket INTEGER PRIMARY KEY,
+		samples INTEGER NOT NULL,
+		cpu_percent_min REAL NOT NULL,
+		cpu_percent_avg REAL NOT NULL,
+		cpu_percent_max REAL NOT NULL,
+		cpu_percent_p95 REAL NOT NULL,
+		mem_percent_min REAL NOT NULL,
+		mem_percent_avg REAL NOT NULL,
+		mem_percent_max REAL NOT NULL,
+		mem_percent_p95 REAL NOT NULL,
+		disk_percent_min REAL NOT NULL,
+		disk_percent_avg REAL NOT NULL,
+		disk_percent_max REAL NOT NULL,
+		disk_percent_p95 REAL NOT NULL,
+		net_rx_bytes_per_sec_min REAL NOT NULL,
+		net_rx_bytes_per_sec_avg REAL NOT NULL,
+		net_rx_bytes_per_sec_max REAL NOT NULL,
+		net_rx_bytes_per_sec_p95 REAL NOT NULL,
+		net_tx_bytes_per_sec_min REAL NOT NULL,
+		net_tx_bytes_per_sec_avg REAL NOT NULL,
+		net_tx_bytes_per_sec_max REAL NOT NULL,
+		net_tx_bytes_per_sec_p95 REAL NOT NULL,
+		disk_read_bytes_per_sec_min REAL NOT NULL,
+		disk_read_bytes_per_sec_avg REAL NOT NULL,
+		disk_read_bytes_per_sec_max REAL NOT NULL,
+		disk_read_bytes_per_sec_p95 REAL NOT NULL,
+		disk_write_bytes_per_sec_min REAL NOT NULL,
+		disk_write_bytes_per_sec_avg REAL NOT NULL,
+		disk_write_bytes_per_sec_max REAL NOT NULL,
+		disk_write_bytes_per_sec_p95 REAL NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS history_1d (
+		bucket INTEGER PRIMARY KEY,
+		samples INTEGER NOT NULL,
+		cpu_percent_min REAL NOT NULL,
+		cpu_percent_avg REAL NOT NULL,
+		cpu_percent_max REAL NOT NULL,
+		cpu_percent_p95 REAL NOT NULL,
+		mem_percent_min REAL NOT NULL,
+		mem_percent_avg REAL NOT NULL,
+		mem_percent_max REAL NOT NULL,
+		mem_percent_p95 REAL NOT NULL,
+		disk_percent_min REAL NOT NULL,
+		disk_percent_avg REAL NOT NULL,
+		disk_percent_max REAL NOT NULL,
+		disk_percent_p95 REAL NOT NULL,
+		net_rx_bytes_per_sec_min REAL NOT NULL,
+		net_rx_bytes_per_sec_avg REAL NOT NULL,
+		net_rx_bytes_per_sec_max REAL NOT NULL,
+		net_rx_bytes_per_sec_p95 REAL NOT NULL,
+		net_tx_bytes_per_sec_min REAL NOT NULL,
+		net_tx_bytes_per_sec_avg REAL NOT NULL,
+		net_tx_bytes_per_sec_max REAL NOT NULL,
+		net_tx_bytes_per_sec_p95 REAL NOT NULL,
+		disk_read_bytes_per_sec_min REAL NOT NULL,
+		disk_read_bytes_per_sec_avg REAL NOT NULL,
+		disk_read_bytes_per_sec_max REAL NOT NULL,
+		disk_read_bytes_per_sec_p95 REAL NOT NULL,
+		disk_write_bytes_per_sec_min REAL NOT NULL,
+		disk_write_bytes_per_sec_avg REAL NOT NULL,
+		disk_write_bytes_per_sec_max REAL NOT NULL,
+		disk_write_bytes_per_sec_p95 REAL NOT NULL
+	);
+	`)},
+	{9, "make host history unique per host and timestamp", execMigration(`
+	DELETE FROM host_history WHERE id NOT IN (SELECT MIN(id) FROM host_history GROUP BY host_id, timestamp);
+	DROP INDEX IF EXISTS idx_host_history_host_timestamp;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_host_history_host_timestamp ON host_history(host_id, timestamp);
+	`)},
+}
+
+// latestSchemaVersion is the version this binary migrates to
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// execMigration returns a migration step that runs fixed SQL
+func execMigration(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumn adds a column to an existing table if it is missing
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// appliedMigrations returns the applied versions and when they were applied
+func appliedMigrations(conn *sql.DB) (map[int]int64, error) {
+	applied := make(map[int]int64)
+	var count int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&count); err != nil || count == 0 {
+		return applied, err
+	}
+	rows, err := conn.Query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var at int64
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// schemaVersion returns the highest applied version (0 for a database from before versioning)
+func schemaVersion(applied map[int]int64) int {
+	current := 0
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	return current
+}
+
+// hasTables reports whether the database holds any data tables (false for a new file)
+func hasTables(conn *sql.DB) (bool, error) {
+	var count int
+	err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')").Scan(&count)
+	return count > 0, err
+}
+
+// backupDB writes a consistent copy of the database next to it and returns its path
+func backupDB(conn *sql.DB, dbPath string, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", dbPath, version, time.Now().Format("20060102-150405"))
+	if _, err := os.Stat(backup); err == nil {
+		return "", fmt.Errorf("backup %s already exists", backup)
+	}
+	if _, err := conn.Exec("VACUUM INTO ?", backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
+
+// migrateDB applies pending migrations, backing up an existing database first
+func migrateDB(conn *sql.DB, dbPath string) error {
+	if _, err := conn.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	current := schemaVersion(applied)
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, latestSchemaVersion())
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if existing, err := hasTables(conn); err != nil {
+		return err
+	} else if existing {
+		backup, err := backupDB(conn, dbPath, current)
+		if err != nil {
+			return fmt.Errorf("failed to back up database before migrating: %w", err)
+		}
+		log.Printf("Database backed up to %s before migrating from schema version %d\n", backup, current)
+	}
+
+	for _, m := range pending {
+		if err := applyMigration(conn, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied database migration %d: %s\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records it in the same transaction
+func applyMigration(conn *sql.DB, m migration) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.Up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.Version, m.Name, time.Now().Unix()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// getDBPath returns the SQLite history database path
+func getDBPath() string {
+	if appConfig.DBPath != "" {
+		return appConfig.DBPath
+	}
+	return filepath.Join(getDataDir(), "sysinfo_history.db")
+}
+
+// runMigrateCommand implements the "migrate" subcommand
+// Stop the service first; the regular flags (-data-dir, -db-path, -config) select the database.
+//
+//	migrate status   list applied and pending migrations
+//	migrate up       back up the database and apply pending migrations
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate status|up [flags]")
+	}
+	action := args[0]
+	if action != "status" && action != "up" {
+		return fmt.Errorf("usage: migrate status|up [flags]")
+	}
+	cfg, err := loadConfig(args[1:])
+	if err != nil {
+		return err
+	}
+	applyConfig(cfg)
+
+	dbPath := getDBPath()
+	if _, err := os.Stat(dbPath); err != nil {
+		return fmt.Errorf("database not found: %w", err)
+	}
+	conn, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer conn.Close()
+
+	if action == "up" {
+		if err := migrateDB(conn, dbPath); err != nil {
+			return err
+		}
+	}
+
+	applied, err := appliedMigrations(conn)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: schema version %d (latest %d)\n", dbPath, schemaVersion(applied), latestSchemaVersion())
+	for _, m := range migrations {
+		state := "pending"
+		if at, ok := applied[m.Version]; ok {
+			state = "applied " + time.Unix(at, 0).Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%3d  %-27s  %s\n", m.Version, state, m.Name)
+	}
+	return nil
+}
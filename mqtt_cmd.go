@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Supported remote commands
+const (
+	mqttCmdPublish      = "publish"       // Publish metrics immediately
+	mqttCmdSetInterval  = "set_interval"  // Change the MQTT publish interval
+	mqttCmdGetProcesses = "get_processes" // Return the top-N processes
+)
+
+// mqttDefaultAllowedCommands are allowed when allowed_commands is empty (read-only commands)
+var mqttDefaultAllowedCommands = []string{mqttCmdPublish, mqttCmdGetProcesses}
+
+// Bounds for remote changes
+const (
+	mqttMinPublishInterval = time.Second
+	mqttMaxProcessLimit    = 100
+)
+
+// MQTTCommand is a request received on <prefix>/<client_id>/cmd
+// MQTT 3.1.1 has no properties, so the v5 response topic and correlation data travel in the payload
+type MQTTCommand struct {
+	Command         string          `json:"command"`
+	Args            json.RawMessage `json:"args,omitempty"`
+	ResponseTopic   string          `json:"response_topic,omitempty"`
+	CorrelationData string          `json:"correlation_data,omitempty"`
+}
+
+// MQTTCommandResponse is published on the response topic
+type MQTTCommandResponse struct {
+	Command         string      `json:"command"`
+	CorrelationData string      `json:"correlation_data,omitempty"`
+	Status          string      `json:"status"` // ok or error
+	Error           string      `json:"error,omitempty"`
+	Result          interface{} `json:"result,omitempty"`
+	Timestamp       int64       `json:"timestamp"`
+}
+
+// Signals the publisher loop that the publish interval changed
+var mqttIntervalChanged = make(chan struct{}, 1)
+
+// mqttCommandTopic returns the topic commands are received on
+func mqttCommandTopic(cfg MQTTConfig, clientID string) string {
+	return fmt.Sprintf("%s/%s/cmd", cfg.TopicPrefix, clientID)
+}
+
+// mqttCommandStatus reports the command settings in /api/mqtt/status
+func mqttCommandStatus(cfg MQTTConfig, clientID string) map[string]interface{} {
+	allowed := cfg.AllowedCommands
+	if len(allowed) == 0 {
+		allowed = mqttDefaultAllowedCommands
+	}
+	return map[string]interface{}{
+		"enabled":          cfg.CommandsEnabled,
+		"topic":            mqttCommandTopic(cfg, clientID),
+		"allowed_commands": allowed,
+		"publish_interval": cfg.PublishInterval,
+	}
+}
+
+// commandAllowed reports whether a command is on the whitelist
+func (c MQTTConfig) commandAllowed(command string) bool {
+	allowed := c.AllowedCommands
+	if len(allowed) == 0 {
+		allowed = mqttDefaultAllowedCommands
+	}
+	for _, a := range allowed {
+		if a == command {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCommands checks the whitelist against the supported commands
+func (c MQTTConfig) validateCommands() error {
+	for _, a := range c.AllowedCommands {
+		switch a {
+		case mqttCmdPublish, mqttCmdSetInterval, mqttCmdGetProcesses:
+		default:
+			return fmt.Errorf("unknown command %q in allowed_commands", a)
+		}
+	}
+	if c.PublishInterval != 0 && time.Duration(c.PublishInterval) < mqttMinPublishInterval {
+		return fmt.Errorf("publish_interval must be at least %v", mqttMinPublishInterval)
+	}
+	return nil
+}
+
+// subscribeMQTTCommands subscribes to the command topic (called on every connect)
+func subscribeMQTTCommands(client mqtt.Client, cfg MQTTConfig, clientID string) {
+	if !cfg.CommandsEnabled {
+		return
+	}
+	topic := mqttCommandTopic(cfg, clientID)
+	token := client.Subscribe(topic, 1, func(c mqtt.Client, msg mqtt.Message) {
+		// Handlers must not block the client's message loop
+		go handleMQTTCommand(c, clientID, msg.Payload())
+	})
+	if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+		log.Printf("MQTT command subscribe error: %v\n", token.Error())
+		return
+	}
+	log.Printf("MQTT commands: listening on %s\n", topic)
+}
+
+// handleMQTTCommand executes a command and publishes the response
+func handleMQTTCommand(client mqtt.Client, clientID string, payload []byte) {
+	mqttMutex.RLock()
+	config := mqttConfig
+	mqttMutex.RUnlock()
+
+	var cmd MQTTCommand
+	resp := MQTTCommandResponse{Status: "ok"}
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		resp.Status, resp.Error = "error", "invalid command: "+err.Error()
+	} else {
+		resp.Command = cmd.Command
+		resp.CorrelationData = cmd.CorrelationData
+		if err := validateResponseTopic(config, clientID, cmd.ResponseTopic); err != nil {
+			resp.Status, resp.Error = "error", err.Error()
+			cmd.ResponseTopic = ""
+		} else if !config.commandAllowed(cmd.Command) {
+			resp.Status, resp.Error = "error", fmt.Sprintf("command %q is not allowed", cmd.Command)
+		} else if result, err := runMQTTCommand(cmd); err != nil {
+			resp.Status, resp.Error = "error", err.Error()
+		} else {
+			resp.Result = result
+			if cmd.Command == mqttCmdSetInterval {
+				// expire_after in the discovery configs follows the publish interval
+				mqttMutex.RLock()
+				updated := mqttConfig
+				mqttMutex.RUnlock()
+				go publishHADiscovery(client, updated, clientID)
+			}
+		}
+	}
+	resp.Timestamp = time.Now().Unix()
+	log.Printf("MQTT command %q: %s\n", cmd.Command, resp.Status)
+
+	topic := cmd.ResponseTopic
+	if topic == "" {
+		topic = mqttCommandTopic(config, clientID) + "/response"
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	token := client.Publish(topic, 1, false, data)
+	if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+		log.Printf("MQTT command response error: %v\n", token.Error())
+	}
+}
+
+// validateResponseTopic keeps responses under <prefix>/<client_id>/, so a command cannot make the agent
+// publish with its credentials to discovery or other hosts' topics (or to its own command topic)
+func validateResponseTopic(cfg MQTTConfig, clientID, topic string) error {
+	if topic == "" {
+		return nil
+	}
+	base := fmt.Sprintf("%s/%s/", cfg.TopicPrefix, clientID)
+	if !strings.HasPrefix(topic, base) || len(topic) == len(base) || strings.ContainsAny(topic, "+#") || topic == mqttCommandTopic(cfg, clientID) {
+		return fmt.Errorf("response_topic must be a topic under %s other than the command topic", base)
+	}
+	return nil
+}
+
+// runMQTTCommand executes a whitelisted command
+func runMQTTCommand(cmd MQTTCommand) (interface{}, error) {
+	switch cmd.Command {
+	case mqttCmdPublish:
+		point, err := publishMetricsNow()
+		if err != nil {
+			return nil, err
+		}
+		return point, nil
+
+	case mqttCmdSetInterval:
+		var args struct {
+			Interval Duration `json:"interval"`
+		}
+		if err := json.Unmarshal(cmd.Args, &args); err != nil {
+			return nil, fmt.Errorf("invalid args: %w", err)
+		}
+		if args.Interval != 0 && time.Duration(args.Interval) < mqttMinPublishInterval {
+			return nil, fmt.Errorf("interval must be 0 (every history tick) or at least %v", mqttMinPublishInterval)
+		}
+		mqttMutex.Lock()
+		mqttConfig.PublishInterval = args.Interval
+		err := saveMQTTConfigLocked()
+		mqttMutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		notifyMQTTPublishInterval()
+		return map[string]interface{}{"interval": args.Interval}, nil
+
+	case mqttCmdGetProcesses:
+		args := struct {
+			Limit int    `json:"limit"`
+			Sort  string `json:"sort"` // cpu (default) or mem
+		}{Limit: 10}
+		if len(cmd.Args) > 0 {
+			if err := json.Unmarshal(cmd.Args, &args); err != nil {
+				return nil, fmt.Errorf("invalid args: %w", err)
+			}
+		}
+		if args.Limit <= 0 || args.Limit > mqttMaxProcessLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", mqttMaxProcessLimit)
+		}
+		processes, err := getProcessList()
+		if err != nil {
+			return nil, err
+		}
+		switch args.Sort {
+		case "", "cpu":
+			// Already sorted by CPU usage
+		case "mem":
+			sort.Slice(processes, func(i, j int) bool {
+				return processes[i].MemPercent > processes[j].MemPercent
+			})
+		default:
+			return nil, fmt.Errorf("sort must be cpu or mem")
+		}
+		if len(processes) > args.Limit {
+			processes = processes[:args.Limit]
+		}
+		return processes, nil
+	}
+	return nil, fmt.Errorf("unknown command %q", cmd.Command)
+}
+
+// publishMetricsNow samples the system and publishes outside the regular schedule
+func publishMetricsNow() (HistoryPoint, error) {
+	info, err := getSystemInfo()
+	if err != nil {
+		return HistoryPoint{}, err
+	}
+	point := newHistoryPoint(info)
+	publishMetrics(point, info)
+	return point, nil
+}
+
+// notifyMQTTPublishInterval wakes the publisher loop after an interval change
+func notifyMQTTPublishInterval() {
+	select {
+	case mqttIntervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// mqttPublishInterval returns the dedicated publish interval (0: publish on every history tick)
+func mqttPublishInterval() time.Duration {
+	mqttMutex.RLock()
+	defer mqttMutex.RUnlock()
+	return time.Duration(mqttConfig.PublishInterval)
+}
+
+// startMQTTPublisher publishes on the dedicated interval when one is configured
+func startMQTTPublisher() {
+	go func() {
+		for {
+			interval := mqttPublishInterval()
+			if interval <= 0 {
+				<-mqttIntervalChanged
+				continue
+			}
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+				if _, err := publishMetricsNow(); err != nil {
+					log.Printf("MQTT publish error: %v\n", err)
+				}
+			case <-mqttIntervalChanged:
+				timer.Stop()
+			}
+		}
+	}()
+}
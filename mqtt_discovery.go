@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Default Home Assistant discovery topic prefix
+const haDefaultDiscoveryPrefix = "homeassistant"
+
+// haSensor describes one Home Assistant sensor backed by a field of the metrics payload
+type haSensor struct {
+	ID            string
+	Name          string
+	MetricTopic   string // Per-metric topic used when the JSON payload is not published
+	ValueTemplate string
+	Unit          string
+	DeviceClass   string
+	StateClass    string
+	Icon          string
+}
+
+// haBaseSensors are published for every host; temperature sensors are added per sensor
+var haBaseSensors = []haSensor{
+	{ID: "cpu", MetricTopic: "cpu", Name: "CPU Usage", ValueTemplate: "{{ value_json.cpu | round(1) }}", Unit: "%", StateClass: "measurement", Icon: "mdi:cpu-64-bit"},
+	{ID: "memory", MetricTopic: "mem", Name: "Memory Usage", ValueTemplate: "{{ value_json.mem | round(1) }}", Unit: "%", StateClass: "measurement", Icon: "mdi:memory"},
+	{ID: "disk", MetricTopic: "disk", Name: "Disk Usage", ValueTemplate: "{{ value_json.disk | round(1) }}", Unit: "%", StateClass: "measurement", Icon: "mdi:harddisk"},
+	{ID: "net_rx", MetricTopic: "net_rx", Name: "Network Receive", ValueTemplate: "{{ value_json.net_rx | round(0) }}", Unit: "B/s", DeviceClass: "data_rate", StateClass: "measurement"},
+	{ID: "net_tx", MetricTopic: "net_tx", Name: "Network Transmit", ValueTemplate: "{{ value_json.net_tx | round(0) }}", Unit: "B/s", DeviceClass: "data_rate", StateClass: "measurement"},
+	{ID: "uptime", MetricTopic: "uptime", Name: "Uptime", ValueTemplate: "{{ value_json.uptime }}", Unit: "s", DeviceClass: "duration", StateClass: "total_increasing", Icon: "mdi:timer-outline"},
+}
+
+// Discovery topics currently published (cleared with empty retained messages)
+var (
+	haDiscoveryTopics = make(map[string]bool)
+	haDiscoveryMutex  sync.Mutex
+)
+
+var haInvalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// haNodeID converts a client ID into a valid discovery node ID
+func haNodeID(clientID string) string {
+	id := strings.Trim(haInvalidIDChars.ReplaceAllString(clientID, "_"), "_")
+	if id == "" {
+		return "sysinfo"
+	}
+	return id
+}
+
+// haDiscoveryPrefix returns the configured discovery prefix
+func haDiscoveryPrefix(cfg MQTTConfig) string {
+	if cfg.HADiscoveryPrefix != "" {
+		return strings.TrimSuffix(cfg.HADiscoveryPrefix, "/")
+	}
+	return haDefaultDiscoveryPrefix
+}
+
+// haSensors returns the base sensors plus one per temperature sensor
+func haSensors(info *SystemInfo) []haSensor {
+	sensors := append([]haSensor{}, haBaseSensors...)
+	if info == nil {
+		return sensors
+	}
+	for _, t := range info.Temperature {
+		key, _ := json.Marshal(t.Name)
+		sensors = append(sensors, haSensor{
+			ID:            "temp_" + strings.ToLower(haNodeID(t.Name)),
+			Name:          "Temperature " + t.Name,
+			MetricTopic:   "temperature/" + mqttTopicSegment(t.Name),
+			ValueTemplate: fmt.Sprintf("{{ value_json.temperatures[%s] }}", key),
+			Unit:          "°C",
+			DeviceClass:   "temperature",
+			StateClass:    "measurement",
+		})
+	}
+	return sensors
+}
+
+// haExpireAfter returns the seconds after which Home Assistant marks a sensor stale:
+// three missed publishes at the dedicated publish interval, or at the history interval without one
+func haExpireAfter(cfg MQTTConfig) int {
+	period := time.Duration(cfg.PublishInterval)
+	if period <= 0 {
+		period = historyInterval
+	}
+	return int((3 * period).Seconds())
+}
+
+// haDiscoveryMessages builds the retained config messages keyed by topic
+func haDiscoveryMessages(cfg MQTTConfig, clientID string, info *SystemInfo) map[string][]byte {
+	node := haNodeID(clientID)
+	stateTopic := fmt.Sprintf("%s/%s", cfg.TopicPrefix, clientID)
+	availability := getMQTTAvailability(cfg, clientID)
+
+	device := map[string]interface{}{
+		"identifiers":  []string{"sysinfo_" + node},
+		"name":         clientID,
+		"manufacturer": "System Monitor API",
+	}
+	if info != nil {
+		device["model"] = strings.TrimSpace(info.Host.Platform + " " + info.Host.OS)
+		if info.CPU.ModelName != "" {
+			device["hw_version"] = info.CPU.ModelName
+		}
+	}
+
+	messages := make(map[string][]byte)
+	for _, s := range haSensors(info) {
+		sensorTopic, valueTemplate := stateTopic, s.ValueTemplate
+		if !cfg.publishesJSON() {
+			sensorTopic, valueTemplate = stateTopic+"/"+s.MetricTopic, "{{ value }}"
+		}
+		config := map[string]interface{}{
+			"name":           s.Name,
+			"unique_id":      node + "_" + s.ID,
+			"object_id":      node + "_" + s.ID,
+			"state_topic":    sensorTopic,
+			"value_template": valueTemplate,
+			"state_class":    s.StateClass,
+			"expire_after":   haExpireAfter(cfg),
+			"device":         device,
+
+			"availability_topic":    availability.Topic,
+			"payload_available":     availability.PayloadOnline,
+			"payload_not_available": availability.PayloadOffline,
+		}
+		if s.Unit != "" {
+			config["unit_of_measurement"] = s.Unit
+		}
+		if s.DeviceClass != "" {
+			config["device_class"] = s.DeviceClass
+		}
+		if s.Icon != "" {
+			config["icon"] = s.Icon
+		}
+		data, err := json.Marshal(config)
+		if err != nil {
+			continue
+		}
+		topic := fmt.Sprintf("%s/sensor/%s/%s/config", haDiscoveryPrefix(cfg), node, s.ID)
+		messages[topic] = data
+	}
+	return messages
+}
+
+// publishHADiscovery publishes retained discovery configs (called on every connect)
+// Sensors that disappeared since the last publish are removed
+func publishHADiscovery(client mqtt.Client, cfg MQTTConfig, clientID string) {
+	if !cfg.HADiscovery || client == nil {
+		return
+	}
+	info, _ := getCachedSystemInfo()
+	messages := haDiscoveryMessages(cfg, clientID, info)
+
+	haDiscoveryMutex.Lock()
+	defer haDiscoveryMutex.Unlock()
+
+	for topic := range haDiscoveryTopics {
+		if _, ok := messages[topic]; !ok {
+			client.Publish(topic, 1, true, []byte{})
+			delete(haDiscoveryTopics, topic)
+		}
+	}
+	for topic, data := range messages {
+		token := client.Publish(topic, 1, true, data)
+		if token.WaitTimeout(5*time.Second) && token.Error() != nil {
+			log.Printf("MQTT discovery publish error: %v\n", token.Error())
+			continue
+		}
+		haDiscoveryTopics[topic] = true
+	}
+	log.Printf("Home Assistant discovery published (%d sensors)\n", len(messages))
+}
+
+// clearHADiscovery removes the discovery configs so Home Assistant drops the sensors
+// This also covers configs retained by a previous run of the program
+func clearHADiscovery(client mqtt.Client, cfg MQTTConfig, clientID string) {
+	if client == nil || !client.IsConnected() {
+		return
+	}
+	info, _ := getCachedSystemInfo()
+
+	haDiscoveryMutex.Lock()
+	defer haDiscoveryMutex.Unlock()
+
+	for topic := range haDiscoveryMessages(cfg, clientID, info) {
+		haDiscoveryTopics[topic] = true
+	}
+	for topic := range haDiscoveryTopics {
+		token := client.Publish(topic, 1, true, []byte{})
+		token.WaitTimeout(5 * time.Second)
+		delete(haDiscoveryTopics, topic)
+	}
+	log.Printf("Home Assistant discovery removed\n")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MQTT payload layouts
+const (
+	mqttLayoutJSON   = "json"   // One JSON document on <prefix>/<client_id>
+	mqttLayoutTopics = "topics" // One plain number per topic below <prefix>/<client_id>/
+	mqttLayoutBoth   = "both"
+)
+
+// mqttMessage is a single topic/payload pair to publish
+type mqttMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+// validate checks the publish settings
+func (c MQTTConfig) validate() error {
+	if c.QoS > 2 {
+		return fmt.Errorf("qos must be 0, 1 or 2")
+	}
+	switch c.Layout {
+	case "", mqttLayoutJSON, mqttLayoutTopics, mqttLayoutBoth:
+	default:
+		return fmt.Errorf("layout must be %q, %q or %q", mqttLayoutJSON, mqttLayoutTopics, mqttLayoutBoth)
+	}
+	return nil
+}
+
+// publishesJSON reports whether the single JSON payload is published
+func (c MQTTConfig) publishesJSON() bool {
+	return c.Layout == "" || c.Layout == mqttLayoutJSON || c.Layout == mqttLayoutBoth
+}
+
+// publishesTopics reports whether per-metric topics are published
+func (c MQTTConfig) publishesTopics() bool {
+	return c.Layout == mqttLayoutTopics || c.Layout == mqttLayoutBoth
+}
+
+// mqttTopicSegment makes a name usable as a single topic level
+func mqttTopicSegment(name string) string {
+	name = strings.Trim(name, "/")
+	if name == "" {
+		return "root"
+	}
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_", " ", "_").Replace(name)
+}
+
+// mqttJSONPayload builds the single JSON document
+// The flat keys are kept for existing consumers; "system" carries the full SystemInfo
+func mqttJSONPayload(clientID string, point HistoryPoint, info *SystemInfo) map[string]interface{} {
+	payload := map[string]interface{}{
+		"hostname":   clientID,
+		"timestamp":  point.Timestamp,
+		"cpu":        point.CPUPercent,
+		"mem":        point.MemPercent,
+		"disk":       point.DiskPercent,
+		"net_rx":     point.NetRxRate,
+		"net_tx":     point.NetTxRate,
+		"disk_read":  point.DiskReadRate,
+		"disk_write": point.DiskWriteRate,
+	}
+	if info == nil {
+		return payload
+	}
+	payload["uptime"] = info.Host.Uptime
+	if len(info.Temperature) > 0 {
+		temps := make(map[string]float64, len(info.Temperature))
+		for _, t := range info.Temperature {
+			temps[t.Name] = t.Temperature
+		}
+		payload["temperatures"] = temps
+	}
+	payload["system"] = info
+	return payload
+}
+
+// mqttMetricMessages builds the per-metric topics, each holding a plain number
+func mqttMetricMessages(base string, point HistoryPoint, info *SystemInfo) []mqttMessage {
+	var messages []mqttMessage
+	add := func(topic string, value float64) {
+		messages = append(messages, mqttMessage{
+			Topic:   base + "/" + topic,
+			Payload: []byte(strconv.FormatFloat(value, 'f', 2, 64)),
+		})
+	}
+	addUint := func(topic string, value uint64) {
+		messages = append(messages, mqttMessage{
+			Topic:   base + "/" + topic,
+			Payload: []byte(strconv.FormatUint(value, 10)),
+		})
+	}
+
+	add("cpu", point.CPUPercent)
+	add("mem", point.MemPercent)
+	add("disk", point.DiskPercent)
+	add("net_rx", point.NetRxRate)
+	add("net_tx", point.NetTxRate)
+	add("disk_read", point.DiskReadRate)
+	add("disk_write", point.DiskWriteRate)
+	if info == nil {
+		return messages
+	}
+
+	addUint("uptime", info.Host.Uptime)
+	for i, v := range info.CPU.UsagePercent {
+		add(fmt.Sprintf("cpu/core/%d", i), v)
+	}
+	addUint("memory/total_bytes", info.Memory.Total)
+	addUint("memory/used_bytes", info.Memory.Used)
+	for _, t := range info.Temperature {
+		add("temperature/"+mqttTopicSegment(t.Name), t.Temperature)
+	}
+	for _, d := range info.Disks {
+		seg := "disks/" + mqttTopicSegment(d.Mountpoint)
+		add(seg+"/used_percent", d.UsedPercent)
+		addUint(seg+"/free_bytes", d.Free)
+	}
+	for _, n := range info.Network.Interfaces {
+		seg := "network/" + mqttTopicSegment(n.Name)
+		add(seg+"/rx_bytes_per_sec", n.RxBytesPerSec)
+		add(seg+"/tx_bytes_per_sec", n.TxBytesPerSec)
+	}
+	for _, d := range info.DiskIO.Devices {
+		seg := "disk_io/" + mqttTopicSegment(d.Name)
+		add(seg+"/read_bytes_per_sec", d.ReadBytesPerSec)
+		add(seg+"/write_bytes_per_sec", d.WriteBytesPerSec)
+		add(seg+"/util_percent", d.UtilPercent)
+	}
+	return messages
+}
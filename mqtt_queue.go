@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Store-and-forward queue defaults
+const (
+	mqttQueueDefaultMaxSize = 10000
+	mqttQueueDefaultMaxAge  = 24 * time.Hour
+	mqttQueueDrainBatch     = 100
+	mqttQueuePublishTimeout = 10 * time.Second
+)
+
+// Queue state (the messages themselves live in the mqtt_queue table)
+var (
+	mqttQueueDepth int
+	mqttQueueMutex sync.Mutex
+	mqttQueueKick  = make(chan struct{}, 1)
+)
+
+// queueLimits returns the effective size and age limits (size 0 means the queue is disabled)
+func (c MQTTConfig) queueLimits() (int, time.Duration) {
+	size, age := c.QueueMaxSize, time.Duration(c.QueueMaxAge)
+	switch {
+	case size < 0:
+		size = 0
+	case size == 0:
+		size = mqttQueueDefaultMaxSize
+	}
+	if age <= 0 {
+		age = mqttQueueDefaultMaxAge
+	}
+	return size, age
+}
+
+// getMQTTQueueDepth returns the number of queued messages
+func getMQTTQueueDepth() int {
+	mqttQueueMutex.Lock()
+	defer mqttQueueMutex.Unlock()
+	return mqttQueueDepth
+}
+
+// startMQTTQueue loads the queue depth and starts the drain worker
+func startMQTTQueue() {
+	dbMutex.Lock()
+	if err := openExistingDBLocked(); err != nil {
+		log.Printf("MQTT queue: %v\n", err)
+	}
+	if db != nil {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM mqtt_queue").Scan(&count); err == nil {
+			mqttQueueMutex.Lock()
+			mqttQueueDepth = count
+			mqttQueueMutex.Unlock()
+		}
+	}
+	dbMutex.Unlock()
+
+	if depth := getMQTTQueueDepth(); depth > 0 {
+		log.Printf("MQTT queue: %d messages waiting from a previous run\n", depth)
+	}
+
+	go func() {
+		for range mqttQueueKick {
+			drainMQTTQueue()
+		}
+	}()
+}
+
+// kickMQTTQueue asks the worker to drain the queue
+func kickMQTTQueue() {
+	select {
+	case mqttQueueKick <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueMQTTMessage stores a message that could not be published
+// The oldest messages are dropped once the queue exceeds its size or age limit
+func enqueueMQTTMessage(cfg MQTTConfig, ts int64, m mqttMessage) error {
+	maxSize, maxAge := cfg.queueLimits()
+	if maxSize == 0 {
+		return nil
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if err := openDBLocked(); err != nil {
+		return err
+	}
+	retain := 0
+	if cfg.Retain {
+		retain = 1
+	}
+	if _, err := db.Exec("INSERT INTO mqtt_queue (timestamp, topic, payload, qos, retain) VALUES (?, ?, ?, ?, ?)",
+		ts, m.Topic, m.Payload, cfg.QoS, retain); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM mqtt_queue WHERE timestamp < ?", time.Now().Add(-maxAge).Unix()); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM mqtt_queue WHERE id NOT IN (SELECT id FROM mqtt_queue ORDER BY id DESC LIMIT ?)", maxSize); err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM mqtt_queue").Scan(&count); err != nil {
+		return err
+	}
+	mqttQueueMutex.Lock()
+	mqttQueueDepth = count
+	mqttQueueMutex.Unlock()
+	return nil
+}
+
+// queuedMQTTMessage is a row of the mqtt_queue table
+type queuedMQTTMessage struct {
+	ID      int64
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// nextQueuedMQTTMessages returns the oldest queued messages, dropping expired ones first
+func nextQueuedMQTTMessages(maxAge time.Duration, limit int) ([]queuedMQTTMessage, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	if _, err := db.Exec("DELETE FROM mqtt_queue WHERE timestamp < ?", time.Now().Add(-maxAge).Unix()); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id, topic, payload, qos, retain FROM mqtt_queue ORDER BY id ASC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []queuedMQTTMessage
+	for rows.Next() {
+		var m queuedMQTTMessage
+		var retain int
+		if err := rows.Scan(&m.ID, &m.Topic, &m.Payload, &m.QoS, &retain); err != nil {
+			return nil, err
+		}
+		m.Retain = retain != 0
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// deleteQueuedMQTTMessage removes a published message and updates the depth
+func deleteQueuedMQTTMessage(id int64) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if _, err := db.Exec("DELETE FROM mqtt_queue WHERE id = ?", id); err != nil {
+		return err
+	}
+	mqttQueueMutex.Lock()
+	if mqttQueueDepth > 0 {
+		mqttQueueDepth--
+	}
+	mqttQueueMutex.Unlock()
+	return nil
+}
+
+// drainMQTTQueue publishes queued messages in order until the queue is empty
+// or the connection drops; a message is only removed once the broker accepted it
+func drainMQTTQueue() {
+	drained := 0
+	for getMQTTQueueDepth() > 0 {
+		mqttMutex.RLock()
+		config := mqttConfig
+		client := mqttClient
+		mqttMutex.RUnlock()
+
+		if !config.Enabled || client == nil || !client.IsConnectionOpen() {
+			break
+		}
+		_, maxAge := config.queueLimits()
+		batch, err := nextQueuedMQTTMessages(maxAge, mqttQueueDrainBatch)
+		if err != nil {
+			log.Printf("MQTT queue read error: %v\n", err)
+			break
+		}
+		if len(batch) == 0 {
+			mqttQueueMutex.Lock()
+			mqttQueueDepth = 0
+			mqttQueueMutex.Unlock()
+			break
+		}
+		if !publishQueuedMQTTMessages(client, batch) {
+			break
+		}
+		drained += len(batch)
+	}
+	if drained > 0 {
+		log.Printf("MQTT queue: published %d queued messages, %d remaining\n", drained, getMQTTQueueDepth())
+	}
+}
+
+// publishQueuedMQTTMessages publishes a batch in order, returning false on the first failure
+func publishQueuedMQTTMessages(client mqtt.Client, batch []queuedMQTTMessage) bool {
+	for _, m := range batch {
+		token := client.Publish(m.Topic, m.QoS, m.Retain, m.Payload)
+		if !token.WaitTimeout(mqttQueuePublishTimeout) || token.Error() != nil {
+			return false
+		}
+		if err := deleteQueuedMQTTMessage(m.ID); err != nil {
+			log.Printf("MQTT queue delete error: %v\n", err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertStatus describes a certificate in /api/mqtt/status
+type CertStatus struct {
+	File          string `json:"file,omitempty"`
+	Subject       string `json:"subject,omitempty"`
+	Issuer        string `json:"issuer,omitempty"`
+	NotBefore     int64  `json:"not_before,omitempty"`
+	NotAfter      int64  `json:"not_after,omitempty"`
+	DaysRemaining int    `json:"days_remaining"`
+	Expired       bool   `json:"expired"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Certificate presented by the broker on the last TLS handshake
+var (
+	mqttBrokerCert      *x509.Certificate
+	mqttBrokerCertMutex sync.Mutex
+)
+
+// mqttUsesTLS reports whether the broker URL selects a TLS transport
+func mqttUsesTLS(broker string) bool {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+		return true
+	}
+	return false
+}
+
+// buildMQTTTLSConfig builds the TLS settings for the broker connection
+// Returns nil if the broker does not use TLS
+func buildMQTTTLSConfig(cfg MQTTConfig) (*tls.Config, error) {
+	if (cfg.ClientCertFile == "") != (cfg.ClientKeyFile == "") {
+		return nil, fmt.Errorf("client_cert_file and client_key_file must be set together")
+	}
+	if !mqttUsesTLS(cfg.Broker) {
+		if cfg.CAFile != "" || cfg.ClientCertFile != "" {
+			return nil, fmt.Errorf("TLS settings require an ssl://, mqtts:// or wss:// broker URL")
+		}
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		NextProtos:         cfg.ALPN,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		// Runs after verification (or instead of it with insecure_skip_verify)
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) > 0 {
+				mqttBrokerCertMutex.Lock()
+				mqttBrokerCert = cs.PeerCertificates[0]
+				mqttBrokerCertMutex.Unlock()
+			}
+			return nil
+		},
+	}
+
+	// A CA file pins trust to that CA instead of the system roots
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newCertStatus summarizes a parsed certificate
+func newCertStatus(cert *x509.Certificate) CertStatus {
+	remaining := time.Until(cert.NotAfter)
+	return CertStatus{
+		Subject:       cert.Subject.String(),
+		Issuer:        cert.Issuer.String(),
+		NotBefore:     cert.NotBefore.Unix(),
+		NotAfter:      cert.NotAfter.Unix(),
+		DaysRemaining: int(remaining.Hours() / 24),
+		Expired:       remaining <= 0,
+	}
+}
+
+// certFileStatus summarizes the first certificate in a PEM file
+func certFileStatus(path string) CertStatus {
+	status := CertStatus{File: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		status.Error = "no PEM certificate found"
+		return status
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	s := newCertStatus(cert)
+	s.File = path
+	return s
+}
+
+// mqttTLSStatus reports the configured and presented certificates
+func mqttTLSStatus(cfg MQTTConfig) map[string]interface{} {
+	status := map[string]interface{}{
+		"enabled":              mqttUsesTLS(cfg.Broker),
+		"insecure_skip_verify": cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		status["ca"] = certFileStatus(cfg.CAFile)
+	}
+	if cfg.ClientCertFile != "" {
+		status["client_cert"] = certFileStatus(cfg.ClientCertFile)
+	}
+	mqttBrokerCertMutex.Lock()
+	if mqttBrokerCert != nil {
+		status["broker_cert"] = newCertStatus(mqttBrokerCert)
+	}
+	mqttBrokerCertMutex.Unlock()
+	return status
+}
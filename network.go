@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// Network collection interval (overridable via config)
+var netCollectInterval = 5 * time.Second
+
+// NetworkInterfaceInfo holds per-interface rates computed from counter deltas
+type NetworkInterfaceInfo struct {
+	Name            string  `json:"name"`
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"`
+	RxErrorsPerSec  float64 `json:"rx_errors_per_sec"`
+	TxErrorsPerSec  float64 `json:"tx_errors_per_sec"`
+	RxDropsPerSec   float64 `json:"rx_drops_per_sec"`
+	TxDropsPerSec   float64 `json:"tx_drops_per_sec"`
+	RxBytesTotal    uint64  `json:"rx_bytes_total"`
+	TxBytesTotal    uint64  `json:"tx_bytes_total"`
+	RxErrorsTotal   uint64  `json:"rx_errors_total"`
+	TxErrorsTotal   uint64  `json:"tx_errors_total"`
+	RxDropsTotal    uint64  `json:"rx_drops_total"`
+	TxDropsTotal    uint64  `json:"tx_drops_total"`
+}
+
+// NetworkInfo is the network section of SystemInfo
+type NetworkInfo struct {
+	RxBytesPerSec float64                `json:"rx_bytes_per_sec"` // Sum over non-loopback interfaces
+	TxBytesPerSec float64                `json:"tx_bytes_per_sec"` // Sum over non-loopback interfaces
+	Interfaces    []NetworkInterfaceInfo `json:"interfaces"`
+}
+
+// Background network collection cache
+var (
+	netInfoCache      NetworkInfo
+	netPrevCounters   map[string]net.IOCountersStat
+	netPrevTime       time.Time
+	netInfoCacheMutex sync.RWMutex
+)
+
+// getCachedNetworkInfo returns the latest rates from the background collector
+func getCachedNetworkInfo() NetworkInfo {
+	netInfoCacheMutex.RLock()
+	defer netInfoCacheMutex.RUnlock()
+
+	result := netInfoCache
+	result.Interfaces = make([]NetworkInterfaceInfo, len(netInfoCache.Interfaces))
+	copy(result.Interfaces, netInfoCache.Interfaces)
+	return result
+}
+
+// isLoopbackInterface reports whether an interface name is a loopback device
+func isLoopbackInterface(name string) bool {
+	lower := strings.ToLower(name)
+	return lower == "lo" || strings.HasPrefix(lower, "lo0") || strings.Contains(lower, "loopback")
+}
+
+// counterDelta returns cur-prev, treating counter resets/wraps as zero
+func counterDelta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+// sampleNetwork reads interface counters and updates the cached rates
+func sampleNetwork() {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+
+	netInfoCacheMutex.Lock()
+	defer netInfoCacheMutex.Unlock()
+
+	current := make(map[string]net.IOCountersStat, len(counters))
+	for _, c := range counters {
+		current[c.Name] = c
+	}
+
+	// The first sample only establishes the baseline
+	if netPrevCounters == nil {
+		netPrevCounters = current
+		netPrevTime = now
+		return
+	}
+
+	elapsed := now.Sub(netPrevTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := func(cur, prev uint64) float64 {
+		return float64(counterDelta(cur, prev)) / elapsed
+	}
+
+	info := NetworkInfo{}
+	for _, c := range counters {
+		prev, ok := netPrevCounters[c.Name]
+		if !ok {
+			continue
+		}
+		iface := NetworkInterfaceInfo{
+			Name:            c.Name,
+			RxBytesPerSec:   rate(c.BytesRecv, prev.BytesRecv),
+			TxBytesPerSec:   rate(c.BytesSent, prev.BytesSent),
+			RxPacketsPerSec: rate(c.PacketsRecv, prev.PacketsRecv),
+			TxPacketsPerSec: rate(c.PacketsSent, prev.PacketsSent),
+			RxErrorsPerSec:  rate(c.Errin, prev.Errin),
+			TxErrorsPerSec:  rate(c.Errout, prev.Errout),
+			RxDropsPerSec:   rate(c.Dropin, prev.Dropin),
+			TxDropsPerSec:   rate(c.Dropout, prev.Dropout),
+			RxBytesTotal:    c.BytesRecv,
+			TxBytesTotal:    c.BytesSent,
+			RxErrorsTotal:   c.Errin,
+			TxErrorsTotal:   c.Errout,
+			RxDropsTotal:    c.Dropin,
+			TxDropsTotal:    c.Dropout,
+		}
+		info.Interfaces = append(info.Interfaces, iface)
+		if !isLoopbackInterface(c.Name) {
+			info.RxBytesPerSec += iface.RxBytesPerSec
+			info.TxBytesPerSec += iface.TxBytesPerSec
+		}
+	}
+	sort.Slice(info.Interfaces, func(i, j int) bool {
+		return info.Interfaces[i].Name < info.Interfaces[j].Name
+	})
+
+	netInfoCache = info
+	netPrevCounters = current
+	netPrevTime = now
+}
+
+// startNetworkCollector starts background network rate collection
+func startNetworkCollector() {
+	sampleNetwork()
+
+	go func() {
+		ticker := time.NewTicker(netCollectInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sampleNetwork()
+		}
+	}()
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// OTLP exporter defaults
+const (
+	otlpDefaultInterval = time.Minute
+	otlpMetricsPath     = "/v1/metrics"
+	otlpMaxAttempts     = 3
+	otlpScopeName       = "sysinfo-api"
+)
+
+// OTLP aggregation temporality (opentelemetry.proto.metrics.v1.AggregationTemporality)
+const otlpTemporalityCumulative = 2
+
+// OTLPConfig is the "otlp" section of the config file
+type OTLPConfig struct {
+	Endpoint           string            `json:"endpoint"` // e.g. http://collector:4318; empty disables the exporter
+	Headers            map[string]string `json:"headers"`
+	Interval           Duration          `json:"interval"`
+	Timeout            Duration          `json:"timeout"`
+	Gzip               bool              `json:"gzip"`
+	CAFile             string            `json:"ca_file"`
+	ClientCertFile     string            `json:"client_cert_file"`
+	ClientKeyFile      string            `json:"client_key_file"`
+	InsecureSkipVerify bool              `json:"insecure_skip_verify"`
+	ResourceAttributes map[string]string `json:"resource_attributes"` // Added to (or overriding) the detected attributes
+}
+
+// enabled reports whether metrics are exported
+func (c *OTLPConfig) enabled() bool {
+	return c.Endpoint != ""
+}
+
+// validate checks the OTLP settings
+func (c *OTLPConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("endpoint must be an http:// or https:// URL")
+	}
+	if c.Interval < Duration(time.Second) {
+		return fmt.Errorf("interval must be at least 1s")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return fmt.Errorf("client_cert_file and client_key_file must be set together")
+	}
+	return nil
+}
+
+// redacted masks header values, which usually carry API keys
+func (c OTLPConfig) redacted() OTLPConfig {
+	if len(c.Headers) > 0 {
+		headers := make(map[string]string, len(c.Headers))
+		for k := range c.Headers {
+			headers[k] = "***"
+		}
+		c.Headers = headers
+	}
+	return c
+}
+
+// metricsURL returns the signal URL; like OTEL_EXPORTER_OTLP_ENDPOINT, /v1/metrics is appended to a base URL
+func (c OTLPConfig) metricsURL() string {
+	if strings.HasSuffix(c.Endpoint, otlpMetricsPath) {
+		return c.Endpoint
+	}
+	return strings.TrimSuffix(c.Endpoint, "/") + otlpMetricsPath
+}
+
+// parseHeaderList parses "k1=v1,k2=v2" (the OTEL_EXPORTER_OTLP_HEADERS format)
+func parseHeaderList(v string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, item := range splitList(v) {
+		k, val, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q (want key=value)", item)
+		}
+		if unescaped, err := url.QueryUnescape(strings.TrimSpace(val)); err == nil {
+			val = unescaped
+		}
+		headers[strings.TrimSpace(k)] = val
+	}
+	return headers, nil
+}
+
+// OTLP/HTTP JSON encoding of opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest
+// (64-bit integers are strings, field names are lowerCamelCase)
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsDouble          *float64       `json:"asDouble,omitempty"`
+	AsInt             *string        `json:"asInt,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Gauge       *otlpGauge `json:"gauge,omitempty"`
+	Sum         *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpScopeMetrics struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	} `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpExportRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+// otlpString returns a string attribute
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+// otlpInt returns an int attribute
+func otlpInt(key string, value int64) otlpKeyValue {
+	s := strconv.FormatInt(value, 10)
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+// otlpHostArch maps kernel architecture names to the host.arch semantic convention values
+func otlpHostArch(kernelArch string) string {
+	switch kernelArch {
+	case "x86_64", "amd64":
+		return "amd64"
+	case "aarch64", "arm64":
+		return "arm64"
+	case "i386", "i686", "x86":
+		return "x86"
+	case "armv7l", "armv6l", "arm":
+		return "arm32"
+	case "ppc64le", "ppc64":
+		return "ppc64"
+	}
+	return kernelArch
+}
+
+// otlpResourceAttributes builds the resource from host.InfoStat plus configured attributes
+func otlpResourceAttributes(hostInfo *host.InfoStat, extra map[string]string) []otlpKeyValue {
+	attrs := map[string]string{
+		"service.name":        "sysinfo-api",
+		"service.instance.id": getEffectiveClientID(),
+	}
+	if hostInfo != nil {
+		attrs["host.name"] = hostInfo.Hostname
+		attrs["host.id"] = hostInfo.HostID
+		attrs["host.arch"] = otlpHostArch(hostInfo.KernelArch)
+		attrs["os.type"] = hostInfo.OS
+		attrs["os.name"] = hostInfo.Platform
+		attrs["os.version"] = hostInfo.PlatformVersion
+		attrs["os.description"] = strings.TrimSpace(fmt.Sprintf("%s %s (kernel %s)", hostInfo.Platform, hostInfo.PlatformVersion, hostInfo.KernelVersion))
+	}
+	for k, v := range extra {
+		attrs[k] = v
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	result := make([]otlpKeyValue, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, otlpString(k, attrs[k]))
+	}
+	return result
+}
+
+// otlpMetricSet collects data points per metric, keeping the order of first use
+type otlpMetricSet struct {
+	now     string
+	start   string // Process-independent start time of cumulative counters (boot time)
+	metrics []otlpMetric
+	index   map[string]int
+}
+
+func (s *otlpMetricSet) metric(name, unit, description string, sum *otlpSum) *otlpMetric {
+	if i, ok := s.index[name]; ok {
+		return &s.metrics[i]
+	}
+	m := otlpMetric{Name: name, Unit: unit, Description: description}
+	if sum != nil {
+		m.Sum = sum
+	} else {
+		m.Gauge = &otlpGauge{}
+	}
+	s.index[name] = len(s.metrics)
+	s.metrics = append(s.metrics, m)
+	return &s.metrics[len(s.metrics)-1]
+}
+
+// gauge adds a double gauge point
+func (s *otlpMetricSet) gauge(name, unit, description string, value float64, attrs ...otlpKeyValue) {
+	m := s.metric(name, unit, description, nil)
+	m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberDataPoint{Attributes: attrs, TimeUnixNano: s.now, AsDouble: &value})
+}
+
+// upDown adds a non-monotonic cumulative int sum point (e.g. memory usage)
+func (s *otlpMetricSet) upDown(name, unit, description string, value uint64, attrs ...otlpKeyValue) {
+	m := s.metric(name, unit, description, &otlpSum{AggregationTemporality: otlpTemporalityCumulative})
+	v := strconv.FormatUint(value, 10)
+	m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{Attributes: attrs, TimeUnixNano: s.now, AsInt: &v})
+}
+
+// counter adds a monotonic cumulative int sum point counting since boot
+func (s *otlpMetricSet) counter(name, unit, description string, value uint64, attrs ...otlpKeyValue) {
+	m := s.metric(name, unit, description, &otlpSum{AggregationTemporality: otlpTemporalityCumulative, IsMonotonic: true})
+	v := strconv.FormatUint(value, 10)
+	m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberDataPoint{Attributes: attrs, StartTimeUnixNano: s.start, TimeUnixNano: s.now, AsInt: &v})
+}
+
+// otlpMetrics maps SystemInfo to the OpenTelemetry host metrics semantic conventions
+func otlpMetrics(info *SystemInfo, now time.Time, bootTime uint64) []otlpMetric {
+	s := &otlpMetricSet{
+		now:   strconv.FormatInt(now.UnixNano(), 10),
+		index: make(map[string]int),
+	}
+	if bootTime > 0 {
+		s.start = strconv.FormatInt(time.Unix(int64(bootTime), 0).UnixNano(), 10)
+	}
+
+	for i, v := range info.CPU.UsagePercent {
+		s.gauge("system.cpu.utilization", "1", "Fraction of time each logical CPU was busy", v/100,
+			otlpInt("cpu.logical_number", int64(i)))
+	}
+	s.upDown("system.cpu.logical.count", "{cpu}", "Number of logical CPUs", uint64(len(info.CPU.UsagePercent)))
+
+	s.upDown("system.memory.usage", "By", "Memory in use by state", info.Memory.Used, otlpString("system.memory.state", "used"))
+	s.upDown("system.memory.usage", "By", "", info.Memory.Free, otlpString("system.memory.state", "free"))
+	s.upDown("system.memory.limit", "By", "Total memory", info.Memory.Total)
+	if info.Memory.Total > 0 {
+		s.gauge("system.memory.utilization", "1", "Fraction of memory by state", float64(info.Memory.Used)/float64(info.Memory.Total),
+			otlpString("system.memory.state", "used"))
+		s.gauge("system.memory.utilization", "1", "", float64(info.Memory.Free)/float64(info.Memory.Total),
+			otlpString("system.memory.state", "free"))
+	}
+
+	for _, d := range info.Disks {
+		attrs := []otlpKeyValue{
+			otlpString("system.device", d.Device),
+			otlpString("system.filesystem.mountpoint", d.Mountpoint),
+			otlpString("system.filesystem.type", d.Fstype),
+		}
+		s.upDown("system.filesystem.usage", "By", "Filesystem space by state", d.Used, append(attrs, otlpString("system.filesystem.state", "used"))...)
+		s.upDown("system.filesystem.usage", "By", "", d.Free, append(attrs, otlpString("system.filesystem.state", "free"))...)
+		s.upDown("system.filesystem.limit", "By", "Filesystem size", d.Total, attrs...)
+		s.gauge("system.filesystem.utilization", "1", "Fraction of filesystem space used", d.UsedPercent/100, attrs...)
+	}
+
+	for _, n := range info.Network.Interfaces {
+		iface := otlpString("network.interface.name", n.Name)
+		s.counter("system.network.io", "By", "Bytes transferred", n.RxBytesTotal, iface, otlpString("network.io.direction", "receive"))
+		s.counter("system.network.io", "By", "", n.TxBytesTotal, iface, otlpString("network.io.direction", "transmit"))
+		s.counter("system.network.errors", "{error}", "Network errors", n.RxErrorsTotal, iface, otlpString("network.io.direction", "receive"))
+		s.counter("system.network.errors", "{error}", "", n.TxErrorsTotal, iface, otlpString("network.io.direction", "transmit"))
+		s.counter("system.network.packet.dropped", "{packet}", "Dropped packets", n.RxDropsTotal, iface, otlpString("network.io.direction", "receive"))
+		s.counter("system.network.packet.dropped", "{packet}", "", n.TxDropsTotal, iface, otlpString("network.io.direction", "transmit"))
+	}
+
+	for _, d := range info.DiskIO.Devices {
+		dev := otlpString("system.device", d.Name)
+		s.counter("system.disk.io", "By", "Bytes read from and written to disk", d.ReadBytesTotal, dev, otlpString("disk.io.direction", "read"))
+		s.counter("system.disk.io", "By", "", d.WriteBytesTotal, dev, otlpString("disk.io.direction", "write"))
+	}
+
+	for _, t := range info.Temperature {
+		s.gauge("hw.temperature", "Cel", "Temperature sensor reading", t.Temperature,
+			otlpString("hw.id", t.Name), otlpString("hw.name", t.Name), otlpString("hw.type", "temperature"))
+	}
+
+	s.gauge("system.uptime", "s", "Time since the system booted", float64(info.Host.Uptime))
+	return s.metrics
+}
+
+// otlpExportBody builds the ExportMetricsServiceRequest JSON
+func otlpExportBody(cfg OTLPConfig, info *SystemInfo, hostInfo *host.InfoStat, now time.Time) ([]byte, error) {
+	var bootTime uint64
+	if hostInfo != nil {
+		bootTime = hostInfo.BootTime
+	}
+	var rm otlpResourceMetrics
+	rm.Resource.Attributes = otlpResourceAttributes(hostInfo, cfg.ResourceAttributes)
+	var sm otlpScopeMetrics
+	sm.Scope.Name = otlpScopeName
+	sm.Metrics = otlpMetrics(info, now, bootTime)
+	rm.ScopeMetrics = []otlpScopeMetrics{sm}
+	return json.Marshal(otlpExportRequest{ResourceMetrics: []otlpResourceMetrics{rm}})
+}
+
+var (
+	otlpClient *http.Client
+	otlpState  spoolStatus
+	otlpMutex  sync.Mutex
+)
+
+// startOTLPExporter exports host metrics on the configured interval
+func startOTLPExporter() {
+	cfg := appConfig.OTLP
+	if !cfg.enabled() {
+		return
+	}
+	client, err := newExporterHTTPClient(cfg.CAFile, cfg.ClientCertFile, cfg.ClientKeyFile, cfg.InsecureSkipVerify, time.Duration(cfg.Timeout))
+	if err != nil {
+		log.Printf("OTLP: %v\n", err)
+		return
+	}
+	otlpClient = client
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.Interval))
+		defer ticker.Stop()
+		for range ticker.C {
+			exportOTLP(cfg)
+		}
+	}()
+	log.Printf("OTLP exporter enabled: %s (interval: %v)\n", cfg.metricsURL(), time.Duration(cfg.Interval))
+}
+
+// exportOTLP sends one snapshot, retrying transient failures with backoff
+// Failed snapshots are not kept: the next export carries the cumulative counters anyway
+func exportOTLP(cfg OTLPConfig) {
+	info, err := getCachedSystemInfo()
+	if err != nil {
+		recordOTLPError(fmt.Errorf("collecting metrics: %w", err))
+		return
+	}
+	hostInfo, _ := getCachedHostInfo()
+	body, err := otlpExportBody(cfg, info, hostInfo, time.Now())
+	if err != nil {
+		recordOTLPError(fmt.Errorf("encoding metrics: %w", err))
+		return
+	}
+
+	header := http.Header{}
+	for k, v := range cfg.Headers {
+		header.Set(k, v)
+	}
+
+	backoff := spoolMinBackoff
+	var serr *sendError
+	for attempt := 1; attempt <= otlpMaxAttempts; attempt++ {
+		serr = postBatch(otlpClient, cfg.metricsURL(), "application/json", body, cfg.Gzip, header)
+		if serr == nil || serr.permanent || attempt == otlpMaxAttempts {
+			break
+		}
+		delay := backoff
+		if serr.retryAfter > delay {
+			delay = serr.retryAfter
+		}
+		if delay >= time.Duration(cfg.Interval) {
+			break
+		}
+		time.Sleep(delay)
+		backoff *= 2
+	}
+
+	if serr != nil {
+		recordOTLPError(serr)
+		return
+	}
+	otlpMutex.Lock()
+	otlpState.LastSuccess = time.Now().Unix()
+	otlpState.Sent++
+	otlpMutex.Unlock()
+}
+
+// recordOTLPError counts a dropped export and reports it in /api/otlp/status
+func recordOTLPError(err error) {
+	otlpMutex.Lock()
+	otlpState.LastError = err.Error()
+	otlpState.LastErrorAt = time.Now().Unix()
+	otlpState.Dropped++
+	otlpMutex.Unlock()
+	log.Printf("OTLP export failed: %v\n", err)
+}
+
+// handleOTLPStatus reports the exporter state (sent/dropped count exports)
+func handleOTLPStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	otlpMutex.Lock()
+	state := otlpState
+	otlpMutex.Unlock()
+
+	cfg := appConfig.OTLP
+	endpoint := ""
+	if cfg.enabled() {
+		endpoint = cfg.metricsURL()
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":  cfg.enabled(),
+		"endpoint": endpoint,
+		"status":   state,
+	})
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// otlpReceiver is a stub OTLP/HTTP collector that keeps the last request
+type otlpReceiver struct {
+	status  int
+	path    string
+	header  http.Header
+	request otlpExportRequest
+}
+
+func newOTLPReceiver(t *testing.T, status int) (*otlpReceiver, *httptest.Server) {
+	t.Helper()
+	recv := &otlpReceiver{status: status}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recv.path = r.URL.Path
+		recv.header = r.Header.Clone()
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("gzip body: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		if err := json.NewDecoder(body).Decode(&recv.request); err != nil {
+			t.Errorf("decode export request: %v", err)
+		}
+		w.WriteHeader(recv.status)
+	}))
+	t.Cleanup(srv.Close)
+
+	prevClient := otlpClient
+	otlpClient = srv.Client()
+	otlpMutex.Lock()
+	otlpState = spoolStatus{}
+	otlpMutex.Unlock()
+	t.Cleanup(func() { otlpClient = prevClient })
+	return recv, srv
+}
+
+func otlpTestConfig(endpoint string) OTLPConfig {
+	return OTLPConfig{
+		Endpoint:           endpoint,
+		Headers:            map[string]string{"X-Api-Key": "secret"},
+		Interval:           Duration(time.Minute),
+		Timeout:            Duration(5 * time.Second),
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+	}
+}
+
+func TestExportOTLP(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		recv, srv := newOTLPReceiver(t, http.StatusOK)
+		cfg := otlpTestConfig(srv.URL)
+		cfg.Gzip = gz
+		exportOTLP(cfg)
+
+		if recv.path != otlpMetricsPath {
+			t.Errorf("gzip=%v: path = %q, want %q", gz, recv.path, otlpMetricsPath)
+		}
+		if got := recv.header.Get("X-Api-Key"); got != "secret" {
+			t.Errorf("gzip=%v: X-Api-Key = %q", gz, got)
+		}
+		if got := recv.header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("gzip=%v: Content-Type = %q", gz, got)
+		}
+		if len(recv.request.ResourceMetrics) != 1 {
+			t.Fatalf("gzip=%v: got %d resourceMetrics, want 1", gz, len(recv.request.ResourceMetrics))
+		}
+		rm := recv.request.ResourceMetrics[0]
+
+		attrs := make(map[string]string)
+		for _, kv := range rm.Resource.Attributes {
+			if kv.Value.StringValue != nil {
+				attrs[kv.Key] = *kv.Value.StringValue
+			}
+		}
+		if attrs["service.name"] != "sysinfo-api" {
+			t.Errorf("gzip=%v: service.name = %q", gz, attrs["service.name"])
+		}
+		if attrs["deployment.environment"] != "test" {
+			t.Errorf("gzip=%v: deployment.environment = %q", gz, attrs["deployment.environment"])
+		}
+		for _, key := range []string{"service.instance.id", "host.name", "host.arch", "os.type"} {
+			if attrs[key] == "" {
+				t.Errorf("gzip=%v: resource attribute %s missing", gz, key)
+			}
+		}
+
+		if len(rm.ScopeMetrics) != 1 || rm.ScopeMetrics[0].Scope.Name != otlpScopeName {
+			t.Fatalf("gzip=%v: unexpected scopeMetrics %+v", gz, rm.ScopeMetrics)
+		}
+		metrics := make(map[string]otlpMetric)
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			metrics[m.Name] = m
+		}
+		for _, name := range []string{
+			"system.cpu.utilization",
+			"system.cpu.logical.count",
+			"system.memory.usage",
+			"system.memory.limit",
+			"system.memory.utilization",
+			"system.uptime",
+		} {
+			m, ok := metrics[name]
+			if !ok {
+				t.Errorf("gzip=%v: metric %s missing", gz, name)
+				continue
+			}
+			if m.Gauge == nil && m.Sum == nil {
+				t.Errorf("gzip=%v: metric %s has no data", gz, name)
+			}
+		}
+
+		otlpMutex.Lock()
+		state := otlpState
+		otlpMutex.Unlock()
+		if state.Sent != 1 || state.Dropped != 0 || state.LastSuccess == 0 {
+			t.Errorf("gzip=%v: state = %+v, want one successful export", gz, state)
+		}
+	}
+}
+
+func TestExportOTLPRejected(t *testing.T) {
+	_, srv := newOTLPReceiver(t, http.StatusBadRequest)
+	exportOTLP(otlpTestConfig(srv.URL + otlpMetricsPath))
+
+	otlpMutex.Lock()
+	state := otlpState
+	otlpMutex.Unlock()
+	if state.Sent != 0 || state.Dropped != 1 || state.LastError == "" || state.LastErrorAt == 0 {
+		t.Errorf("state = %+v, want one dropped export with an error", state)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Push exporter defaults
+const (
+	pushDefaultBatchSize = 100
+	pushDefaultTimeout   = 10 * time.Second
+)
+
+// PushConfig is the "push" section of the config file
+type PushConfig struct {
+	URL                string   `json:"url"` // Empty disables the exporter
+	Token              string   `json:"token"`
+	BatchSize          int      `json:"batch_size"`
+	FlushInterval      Duration `json:"flush_interval"` // 0: send on every history point
+	Gzip               bool     `json:"gzip"`
+	Timeout            Duration `json:"timeout"`
+	CAFile             string   `json:"ca_file"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+	SpoolMaxSize       int      `json:"spool_max_size"` // 0: default, -1: no spool
+	SpoolMaxAge        Duration `json:"spool_max_age"`
+}
+
+// enabled reports whether points are pushed
+func (p *PushConfig) enabled() bool {
+	return p.URL != ""
+}
+
+// validate checks the push settings
+func (p *PushConfig) validate() error {
+	if !p.enabled() {
+		return nil
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http:// or https:// URL")
+	}
+	if p.BatchSize < 1 {
+		return fmt.Errorf("batch_size must be at least 1")
+	}
+	if p.FlushInterval < 0 || p.Timeout <= 0 || p.SpoolMaxAge < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	return nil
+}
+
+// redacted masks the bearer token
+func (p PushConfig) redacted() PushConfig {
+	if p.Token != "" {
+		p.Token = "***"
+	}
+	return p
+}
+
+// spoolLimits returns the effective size and age limits (size 0 means the spool is disabled)
+func (p PushConfig) spoolLimits() (int, time.Duration) {
+	return spoolLimits(p.SpoolMaxSize, p.SpoolMaxAge)
+}
+
+var (
+	pushClient *http.Client
+	pushSpool  = newSpool("Push", "push_spool")
+)
+
+// startPushExporter loads the spool depth and starts the sender
+func startPushExporter() {
+	cfg := appConfig.Push
+	if !cfg.enabled() {
+		return
+	}
+	client, err := newExporterHTTPClient(cfg.CAFile, "", "", cfg.InsecureSkipVerify, time.Duration(cfg.Timeout))
+	if err != nil {
+		log.Printf("Push: %v\n", err)
+		return
+	}
+	pushClient = client
+
+	pushSpool.load()
+	pushSpool.run(time.Duration(cfg.FlushInterval), cfg.BatchSize, func(payloads [][]byte) *sendError {
+		return sendPushBatch(cfg, payloads)
+	})
+	log.Printf("Push exporter enabled: %s\n", cfg.URL)
+}
+
+// pushPoint queues a history point for the sender (called from collectHistory)
+func pushPoint(point HistoryPoint, info *SystemInfo) {
+	cfg := appConfig.Push
+	if !cfg.enabled() || pushClient == nil {
+		return
+	}
+	payload, err := json.Marshal(mqttJSONPayload(getEffectiveClientID(), point, info))
+	if err != nil {
+		return
+	}
+	maxSize, maxAge := cfg.spoolLimits()
+	if err := pushSpool.enqueue(point.Timestamp, payload, maxSize, maxAge); err != nil {
+		log.Printf("Push: failed to spool point: %v\n", err)
+		return
+	}
+	pushSpool.wake()
+}
+
+// sendPushBatch POSTs payloads as a JSON array
+func sendPushBatch(cfg PushConfig, payloads [][]byte) *sendError {
+	body := append([]byte("["), bytes.Join(payloads, []byte(","))...)
+	body = append(body, ']')
+
+	header := http.Header{}
+	if cfg.Token != "" {
+		header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	return postBatch(pushClient, cfg.URL, "application/json", body, cfg.Gzip, header)
+}
+
+// handlePushStatus reports the exporter state
+func handlePushStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":     appConfig.Push.enabled(),
+		"url":         appConfig.Push.URL,
+		"spool_depth": pushSpool.getDepth(),
+		"status":      pushSpool.getStatus(),
+	})
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// History rollup settings
+const (
+	rollupInterval      = 5 * time.Minute // How often complete buckets are rolled up and old rows pruned
+	rollupGrace         = 60              // Seconds after a bucket ends before it is considered complete
+	rollupChunk         = 24 * 60 * 60    // Raw rows are read at most one day at a time
+	historyMaxPoints    = 2000            // handleHistory picks the finest resolution below this many points
+	defaultRawRetention = 7 * 24 * time.Hour
+	default5mRetention  = 90 * 24 * time.Hour
+	default1hRetention  = 2 * 365 * 24 * time.Hour
+)
+
+// rollupTier is one downsampled resolution of the history table
+type rollupTier struct {
+	Name  string // Also the value of the step parameter
+	Table string
+	Step  int64 // Bucket size in seconds
+}
+
+var rollupTiers = []rollupTier{
+	{"5m", "history_5m", 5 * 60},
+	{"1h", "history_1h", 60 * 60},
+	{"1d", "history_1d", 24 * 60 * 60},
+}
+
+// findRollupTier returns the tier with the given name
+func findRollupTier(name string) *rollupTier {
+	for i := range rollupTiers {
+		if rollupTiers[i].Name == name {
+			return &rollupTiers[i]
+		}
+	}
+	return nil
+}
+
+// History columns that are rolled up, in HistoryPoint order
+var rollupMetrics = []string{
+	"cpu_percent", "mem_percent", "disk_percent",
+	"net_rx_bytes_per_sec", "net_tx_bytes_per_sec",
+	"disk_read_bytes_per_sec", "disk_write_bytes_per_sec",
+}
+
+// Statistics stored per metric, as <metric>_<fn> columns
+var rollupFuncs = []string{"min", "avg", "max", "p95"}
+
+// RetentionConfig is the "retention" section of the config file (0 keeps data forever)
+type RetentionConfig struct {
+	Raw         Duration `json:"raw"`
+	FiveMinutes Duration `json:"5m"`
+	Hour        Duration `json:"1h"`
+	Day         Duration `json:"1d"`
+}
+
+// validate checks the retention settings
+func (c *RetentionConfig) validate() error {
+	if c.Raw < 0 || c.FiveMinutes < 0 || c.Hour < 0 || c.Day < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	return nil
+}
+
+// forTier returns the retention of a tier ("raw" for the history table)
+func (c RetentionConfig) forTier(name string) time.Duration {
+	switch name {
+	case "raw":
+		return time.Duration(c.Raw)
+	case "5m":
+		return time.Duration(c.FiveMinutes)
+	case "1h":
+		return time.Duration(c.Hour)
+	case "1d":
+		return time.Duration(c.Day)
+	}
+	return 0
+}
+
+// RollupStats summarizes one metric in one bucket
+type RollupStats struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+	P95 float64 `json:"p95"`
+}
+
+// RollupPoint is one bucket of a rollup table
+type RollupPoint struct {
+	Timestamp int64       `json:"ts"` // Bucket start
+	Samples   int         `json:"samples"`
+	CPU       RollupStats `json:"cpu"`
+	Mem       RollupStats `json:"mem"`
+	Disk      RollupStats `json:"disk"`
+	NetRx     RollupStats `json:"net_rx"`
+	NetTx     RollupStats `json:"net_tx"`
+	DiskRead  RollupStats `json:"disk_read"`
+	DiskWrite RollupStats `json:"disk_write"`
+}
+
+// stats returns the per-metric statistics in rollupMetrics order
+func (p *RollupPoint) stats() []*RollupStats {
+	return []*RollupStats{&p.CPU, &p.Mem, &p.Disk, &p.NetRx, &p.NetTx, &p.DiskRead, &p.DiskWrite}
+}
+
+// average returns the bucket averages as a history point
+func (p *RollupPoint) average() HistoryPoint {
+	return HistoryPoint{
+		Timestamp:     p.Timestamp,
+		CPUPercent:    p.CPU.Avg,
+		MemPercent:    p.Mem.Avg,
+		DiskPercent:   p.Disk.Avg,
+		NetRxRate:     p.NetRx.Avg,
+		NetTxRate:     p.NetTx.Avg,
+		DiskReadRate:  p.DiskRead.Avg,
+		DiskWriteRate: p.DiskWrite.Avg,
+	}
+}
+
+// historyJSON returns the bucket as a history point object: the averages under the usual keys,
+// plus samples and <metric>_min, <metric>_max and <metric>_p95
+func (p *RollupPoint) historyJSON() map[string]interface{} {
+	obj := map[string]interface{}{"ts": p.Timestamp, "samples": p.Samples}
+	for i, s := range p.stats() {
+		name := historyMetricNames[i]
+		obj[name] = s.Avg
+		obj[name+"_min"] = s.Min
+		obj[name+"_max"] = s.Max
+		obj[name+"_p95"] = s.P95
+	}
+	return obj
+}
+
+// rollupCSVHeader lists the columns rollupCSVRecord appends to a history CSV row
+func rollupCSVHeader() []string {
+	cols := []string{"samples"}
+	for _, m := range rollupMetrics {
+		cols = append(cols, m+"_min", m+"_max", m+"_p95")
+	}
+	return cols
+}
+
+// rollupCSVRecord returns the sample count and the min/max/p95 of every metric
+func (p *RollupPoint) rollupCSVRecord() []string {
+	record := []string{strconv.Itoa(p.Samples)}
+	for _, s := range p.stats() {
+		record = append(record, fmt.Sprintf("%.2f", s.Min), fmt.Sprintf("%.2f", s.Max), fmt.Sprintf("%.2f", s.P95))
+	}
+	return record
+}
+
+// historyValues returns the values of a history point in rollupMetrics order
+func historyValues(p HistoryPoint) []float64 {
+	return []float64{p.CPUPercent, p.MemPercent, p.DiskPercent, p.NetRxRate, p.NetTxRate, p.DiskReadRate, p.DiskWriteRate}
+}
+
+// rollupColumns lists the statistic columns of a rollup table
+func rollupColumns() []string {
+	var cols []string
+	for _, m := range rollupMetrics {
+		for _, fn := range rollupFuncs {
+			cols = append(cols, m+"_"+fn)
+		}
+	}
+	return cols
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// summarize computes min/avg/max/p95 of a set of values
+func summarize(values []float64) RollupStats {
+	if len(values) == 0 {
+		return RollupStats{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return RollupStats{
+		Min: sorted[0],
+		Avg: sum / float64(len(sorted)),
+		Max: sorted[len(sorted)-1],
+		P95: percentile(sorted, 95),
+	}
+}
+
+// rollupBuckets groups raw points (sorted by timestamp) into buckets of step seconds
+func rollupBuckets(points []HistoryPoint, step int64) []RollupPoint {
+	var result []RollupPoint
+	for i := 0; i < len(points); {
+		bucket := points[i].Timestamp / step * step
+		j := i
+		for j < len(points) && points[j].Timestamp/step*step == bucket {
+			j++
+		}
+
+		rp := RollupPoint{Timestamp: bucket, Samples: j - i}
+		for m, stats := range rp.stats() {
+			values := make([]float64, 0, j-i)
+			for _, p := range points[i:j] {
+				values = append(values, historyValues(p)[m])
+			}
+			*stats = summarize(values)
+		}
+		result = append(result, rp)
+		i = j
+	}
+	return result
+}
+
+// saveRollups writes buckets to a tier, replacing buckets that were already rolled up
+func saveRollups(t rollupTier, buckets []RollupPoint) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	cols := rollupColumns()
+	placeholders := strings.Repeat(", ?", len(cols))
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %s (bucket, samples, %s) VALUES (?, ?%s)", t.Table, strings.Join(cols, ", "), placeholders))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, b := range buckets {
+		args := []interface{}{b.Timestamp, b.Samples}
+		for _, s := range b.stats() {
+			args = append(args, s.Min, s.Avg, s.Max, s.P95)
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queryRollupsFromDB returns the buckets of a tier that overlap [startTime, endTime]
+func queryRollupsFromDB(t rollupTier, startTime, endTime int64) ([]RollupPoint, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(
+		fmt.Sprintf("SELECT bucket, samples, %s FROM %s WHERE bucket > ? AND bucket <= ? ORDER BY bucket ASC", strings.Join(rollupColumns(), ", "), t.Table),
+		startTime-t.Step, endTime,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []RollupPoint
+	for rows.Next() {
+		var p RollupPoint
+		dest := []interface{}{&p.Timestamp, &p.Samples}
+		for _, s := range p.stats() {
+			dest = append(dest, &s.Min, &s.Avg, &s.Max, &s.P95)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+// nextRawTimestamp returns the first raw timestamp at or after ts (ok is false if there is none)
+func nextRawTimestamp(ts int64) (next int64, ok bool, err error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return 0, false, fmt.Errorf("database not initialized")
+	}
+	var v sql.NullInt64
+	if err := db.QueryRow("SELECT MIN(timestamp) FROM history WHERE timestamp >= ?", ts).Scan(&v); err != nil {
+		return 0, false, err
+	}
+	return v.Int64, v.Valid, nil
+}
+
+// lastRollupBucket returns the newest bucket of a tier (ok is false if the tier is empty)
+func lastRollupBucket(t rollupTier) (bucket int64, ok bool, err error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return 0, false, fmt.Errorf("database not initialized")
+	}
+	var v sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(bucket) FROM " + t.Table).Scan(&v); err != nil {
+		return 0, false, err
+	}
+	return v.Int64, v.Valid, nil
+}
+
+// rollupTierUntil rolls up all complete buckets before end and returns the first bucket left for later
+func rollupTierUntil(t rollupTier, end int64) (int64, error) {
+	end = end / t.Step * t.Step
+	from := int64(math.MinInt64)
+	if last, ok, err := lastRollupBucket(t); err != nil {
+		return 0, err
+	} else if ok {
+		from = last + t.Step
+	}
+
+	for {
+		// Skip gaps in the raw data instead of scanning them day by day
+		next, ok, err := nextRawTimestamp(from)
+		if err != nil {
+			return 0, err
+		}
+		if !ok || next >= end {
+			return end, nil
+		}
+		from = next / t.Step * t.Step
+
+		to := from + rollupChunk
+		if to < from+t.Step {
+			to = from + t.Step
+		}
+		to = to / t.Step * t.Step
+		if to > end {
+			to = end
+		}
+
+		points, err := queryHistoryFromDB(from, to-1)
+		if err != nil {
+			return 0, err
+		}
+		if err := saveRollups(t, rollupBuckets(points, t.Step)); err != nil {
+			return 0, err
+		}
+		from = to
+	}
+}
+
+// runRollups rolls up every tier, then prunes rows older than their retention
+// Raw rows are only pruned once every tier has rolled them up
+func runRollups(now time.Time) error {
+	// Buckets holding points queued for the next flush are rolled up once those are committed
+	until := now.Unix() - rollupGrace
+	if queued := pendingHistoryPoints(); len(queued) > 0 && queued[0].Timestamp < until {
+		until = queued[0].Timestamp
+	}
+	rolledThrough := now.Unix()
+	for _, t := range rollupTiers {
+		next, err := rollupTierUntil(t, until)
+		if err != nil {
+			return fmt.Errorf("failed to roll up history into %s: %w", t.Table, err)
+		}
+		if next < rolledThrough {
+			rolledThrough = next
+		}
+	}
+	return pruneHistory(now.Unix(), rolledThrough)
+}
+
+// pruneHistory deletes raw, rolled-up and disk rows past their retention
+func pruneHistory(now, rolledThrough int64) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if r := appConfig.Retention.forTier("raw"); r > 0 {
+		cutoff := now - int64(r.Seconds())
+		if cutoff > rolledThrough {
+			cutoff = rolledThrough
+		}
+		if _, err := db.Exec("DELETE FROM history WHERE timestamp < ?", cutoff); err != nil {
+			return err
+		}
+		// Per-disk and disk I/O history is not rolled up and follows the raw retention
+		for _, table := range []string{"disk_history", "disk_io_history"} {
+			if _, err := db.Exec("DELETE FROM "+table+" WHERE timestamp < ?", now-int64(r.Seconds())); err != nil {
+				return err
+			}
+		}
+	}
+	for _, t := range rollupTiers {
+		if r := appConfig.Retention.forTier(t.Name); r > 0 {
+			if _, err := db.Exec("DELETE FROM "+t.Table+" WHERE bucket < ?", now-int64(r.Seconds())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startHistoryMaintenance prunes the history store on start and every rollupInterval
+// For SQLite this also rolls up existing history (backfilling on first start) and keeps the tiers current
+func startHistoryMaintenance() {
+	go func() {
+		ticker := time.NewTicker(rollupInterval)
+		defer ticker.Stop()
+		for {
+			if err := historyStore.Prune(time.Now()); err != nil {
+				log.Printf("History maintenance failed: %v\n", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// retentionCovers reports whether a tier ("raw" for the history table) still holds data from startTime
+func retentionCovers(name string, startTime int64) bool {
+	r := appConfig.Retention.forTier(name)
+	return r <= 0 || startTime >= time.Now().Unix()-int64(r.Seconds())
+}
+
+// pickHistoryTier returns the finest tier that still holds startTime and keeps the range
+// below historyMaxPoints (nil means raw data)
+func pickHistoryTier(startTime, endTime int64) *rollupTier {
+	span := endTime - startTime
+	if interval := int64(historyInterval.Seconds()); interval > 0 && span/interval <= historyMaxPoints && retentionCovers("raw", startTime) {
+		return nil
+	}
+	for i := range rollupTiers {
+		if span/rollupTiers[i].Step <= historyMaxPoints && retentionCovers(rollupTiers[i].Name, startTime) {
+			return &rollupTiers[i]
+		}
+	}
+	return &rollupTiers[len(rollupTiers)-1]
+}
+
+// getRollupStats returns the row count and time range of a tier
+func getRollupStats(t rollupTier) (minTime, maxTime int64, count int64, err error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return 0, 0, 0, fmt.Errorf("database not initialized")
+	}
+
+	err = db.QueryRow("SELECT COALESCE(MIN(bucket), 0), COALESCE(MAX(bucket), 0), COUNT(*) FROM "+t.Table).Scan(&minTime, &maxTime, &count)
+	return
+}
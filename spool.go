@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Spool limits and retry backoff for HTTP exporters
+const (
+	spoolDefaultMaxSize = 10000
+	spoolDefaultMaxAge  = 24 * time.Hour
+	spoolMinBackoff     = time.Second
+	spoolMaxBackoff     = 5 * time.Minute
+)
+
+// spoolLimits returns the effective size and age limits (size 0 means the spool is disabled)
+func spoolLimits(size int, maxAge Duration) (int, time.Duration) {
+	age := time.Duration(maxAge)
+	switch {
+	case size < 0:
+		size = 0
+	case size == 0:
+		size = spoolDefaultMaxSize
+	}
+	if age <= 0 {
+		age = spoolDefaultMaxAge
+	}
+	return size, age
+}
+
+// spoolStatus is reported by the exporters' status endpoints
+type spoolStatus struct {
+	LastSuccess int64  `json:"last_success,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+	Sent        uint64 `json:"sent"`
+	Dropped     uint64 `json:"dropped"`
+}
+
+// sendError is a failed delivery; permanent errors are not retried
+type sendError struct {
+	err        error
+	permanent  bool
+	retryAfter time.Duration
+}
+
+func (e *sendError) Error() string { return e.err.Error() }
+
+// spoolEntry is a payload held in memory until it is sent or spooled
+type spoolEntry struct {
+	ts      int64
+	payload []byte
+}
+
+// spool is a FIFO of payloads waiting for an HTTP receiver
+// Payloads are sent from memory while the receiver accepts them and only written to the
+// spool's table (id, timestamp, payload) after a failed send, until the table is drained
+type spool struct {
+	name    string // Log prefix
+	table   string
+	kick    chan struct{}
+	mutex   sync.Mutex
+	depth   int          // Rows in the table
+	buffer  []spoolEntry // Newer than every row in the table
+	maxSize int          // Limits from the last enqueue, applied when the buffer is spooled
+	maxAge  time.Duration
+	status  spoolStatus
+}
+
+// newSpool returns a spool backed by an existing table
+func newSpool(name, table string) *spool {
+	return &spool{name: name, table: table, kick: make(chan struct{}, 1)}
+}
+
+// load reads the depth left over from a previous run
+func (s *spool) load() {
+	dbMutex.Lock()
+	if err := openExistingDBLocked(); err != nil {
+		log.Printf("%s: %v\n", s.name, err)
+	}
+	if db != nil {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + s.table).Scan(&count); err == nil {
+			s.mutex.Lock()
+			s.depth = count
+			s.mutex.Unlock()
+		}
+	}
+	dbMutex.Unlock()
+
+	if depth := s.getDepth(); depth > 0 {
+		log.Printf("%s: %d points waiting from a previous run\n", s.name, depth)
+	}
+}
+
+// getDepth returns the number of unsent payloads, spooled or in memory
+func (s *spool) getDepth() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.depth + len(s.buffer)
+}
+
+// spooled returns the number of payloads in the table
+func (s *spool) spooled() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.depth
+}
+
+// getStatus returns the delivery counters
+func (s *spool) getStatus() spoolStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.status
+}
+
+// wake asks the sender to drain the spool
+func (s *spool) wake() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+// enqueue hands a payload to the sender
+// It stays in memory while the table is empty, so a healthy receiver costs no database writes;
+// during an outage it goes straight to the table. maxSize 0 disables the table and only the
+// newest unsent payload is kept
+func (s *spool) enqueue(ts int64, payload []byte, maxSize int, maxAge time.Duration) error {
+	s.mutex.Lock()
+	s.maxSize, s.maxAge = maxSize, maxAge
+	if s.depth > 0 && maxSize > 0 {
+		s.mutex.Unlock()
+		return s.store([]spoolEntry{{ts, payload}}, maxSize, maxAge)
+	}
+	s.buffer = append(s.buffer, spoolEntry{ts, payload})
+	if n := len(s.buffer) - max(maxSize, 1); n > 0 {
+		s.buffer = s.buffer[n:]
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
+// takeBuffered removes up to limit of the oldest payloads held in memory
+func (s *spool) takeBuffered(limit int) []spoolEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	n := min(limit, len(s.buffer))
+	entries := append([]spoolEntry(nil), s.buffer[:n]...)
+	s.buffer = s.buffer[n:]
+	return entries
+}
+
+// spill writes the payloads held in memory (preceded by entries taken for a failed send)
+// to the table so they survive the outage and restarts
+func (s *spool) spill(entries []spoolEntry) error {
+	s.mutex.Lock()
+	entries = append(entries, s.buffer...)
+	maxSize, maxAge := s.maxSize, s.maxAge
+	if maxSize == 0 || len(entries) == 0 {
+		s.buffer = entries
+		s.mutex.Unlock()
+		return nil
+	}
+	s.buffer = nil
+	s.mutex.Unlock()
+
+	if err := s.store(entries, maxSize, maxAge); err != nil {
+		s.mutex.Lock()
+		s.buffer = append(entries, s.buffer...)
+		s.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// store appends payloads to the table in one transaction
+// The oldest payloads are dropped once the spool exceeds its size or age limit
+func (s *spool) store(entries []spoolEntry, maxSize int, maxAge time.Duration) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if err := openDBLocked(); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, e := range entries {
+		if _, err := tx.Exec("INSERT INTO "+s.table+" (timestamp, payload) VALUES (?, ?)", e.ts, e.payload); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM "+s.table+" WHERE timestamp < ?", time.Now().Add(-maxAge).Unix()); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM "+s.table+" WHERE id NOT IN (SELECT id FROM "+s.table+" ORDER BY id DESC LIMIT ?)", maxSize); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return s.updateDepthLocked()
+}
+
+// next returns the oldest spooled payloads
+func (s *spool) next(limit int) ([]int64, [][]byte, error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return nil, nil, fmt.Errorf("database not initialized")
+	}
+	rows, err := db.Query("SELECT id, payload FROM "+s.table+" ORDER BY id ASC LIMIT ?", limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var payloads [][]byte
+	for rows.Next() {
+		var id int64
+		var payload []byte
+		if err := rows.Scan(&id, &payload); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, id)
+		payloads = append(payloads, payload)
+	}
+	return ids, payloads, rows.Err()
+}
+
+// deleteThrough removes delivered payloads up to and including lastID
+func (s *spool) deleteThrough(lastID int64) error {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if _, err := db.Exec("DELETE FROM "+s.table+" WHERE id <= ?", lastID); err != nil {
+		return err
+	}
+	return s.updateDepthLocked()
+}
+
+// updateDepthLocked recounts the table (dbMutex must be held)
+func (s *spool) updateDepthLocked() error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + s.table).Scan(&count); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.depth = count
+	s.mutex.Unlock()
+	return nil
+}
+
+// run starts the sender: every wake-up (flushInterval 0) or every flushInterval,
+// or as soon as a full batch is waiting
+func (s *spool) run(flushInterval time.Duration, batchSize int, send func([][]byte) *sendError) {
+	go func() {
+		var tick <-chan time.Time
+		if flushInterval > 0 {
+			ticker := time.NewTicker(flushInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		s.wake()
+		for {
+			select {
+			case <-s.kick:
+				if flushInterval > 0 && s.getDepth() < batchSize {
+					continue
+				}
+			case <-tick:
+			}
+			s.drain(batchSize, send)
+		}
+	}()
+}
+
+// drain sends spooled payloads in order, then the ones held in memory,
+// backing off exponentially while the receiver fails
+func (s *spool) drain(batchSize int, send func([][]byte) *sendError) {
+	backoff := spoolMinBackoff
+	for {
+		var ids []int64
+		var payloads [][]byte
+		var entries []spoolEntry
+		if s.spooled() > 0 {
+			var err error
+			if ids, payloads, err = s.next(batchSize); err != nil {
+				log.Printf("%s: spool read error: %v\n", s.name, err)
+				return
+			}
+		}
+		if len(ids) == 0 {
+			entries = s.takeBuffered(batchSize)
+			for _, e := range entries {
+				payloads = append(payloads, e.payload)
+			}
+		}
+		if len(payloads) == 0 {
+			return
+		}
+
+		serr := send(payloads)
+		s.mutex.Lock()
+		if serr == nil {
+			s.status.LastSuccess = time.Now().Unix()
+			s.status.Sent += uint64(len(payloads))
+		} else {
+			s.status.LastError = serr.Error()
+			s.status.LastErrorAt = time.Now().Unix()
+			if serr.permanent {
+				s.status.Dropped += uint64(len(payloads))
+			}
+		}
+		s.mutex.Unlock()
+
+		if serr != nil && !serr.permanent {
+			// Keep everything unsent on disk from now on
+			if err := s.spill(entries); err != nil {
+				log.Printf("%s: failed to spool points: %v\n", s.name, err)
+			}
+			delay := backoff
+			if serr.retryAfter > delay {
+				delay = serr.retryAfter
+			}
+			log.Printf("%s: %v (retrying in %v, %d points waiting)\n", s.name, serr, delay, s.getDepth())
+			time.Sleep(delay)
+			backoff *= 2
+			if backoff > spoolMaxBackoff {
+				backoff = spoolMaxBackoff
+			}
+			continue
+		}
+		if serr != nil {
+			log.Printf("%s: batch of %d points rejected: %v\n", s.name, len(payloads), serr)
+		}
+		backoff = spoolMinBackoff
+		if len(ids) > 0 {
+			if err := s.deleteThrough(ids[len(ids)-1]); err != nil {
+				log.Printf("%s: spool delete error: %v\n", s.name, err)
+				return
+			}
+		}
+	}
+}
+
+// httpSendError classifies a non-2xx response
+// Rate limits and server errors are retried; so are auth and not-found responses, which
+// usually mean a configuration problem on the receiver that should not lose data
+func httpSendError(resp *http.Response) *sendError {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	serr := &sendError{err: fmt.Errorf("server returned %s", resp.Status)}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			serr.retryAfter = time.Duration(secs) * time.Second
+		}
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusForbidden,
+		resp.StatusCode == http.StatusNotFound:
+	default:
+		serr.permanent = true
+	}
+	return serr
+}
+
+// newExporterHTTPClient returns an HTTP client that trusts caFile instead of the system roots if set
+// and presents certFile/keyFile as client certificate if set
+func newExporterHTTPClient(caFile, certFile, keyFile string, insecureSkipVerify bool, timeout time.Duration) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// postBatch POSTs a body, gzip-compressed if requested, and classifies the result
+func postBatch(client *http.Client, url, contentType string, body []byte, compress bool, header http.Header) *sendError {
+	var reader io.Reader = bytes.NewReader(body)
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		zw.Close()
+		reader = &buf
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, reader)
+	if err != nil {
+		return &sendError{err: err, permanent: true}
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "sysinfo-api")
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &sendError{err: err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	return httpSendError(resp)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// Largest StatsD datagram; stays below common MTUs to avoid fragmentation
+const statsdMaxPacketSize = 1432
+
+// StatsDConfig is the "statsd" section of the config file
+type StatsDConfig struct {
+	Address  string `json:"address"`  // host:port of the StatsD server (usually 8125); empty disables
+	Template string `json:"template"` // Metric path, e.g. servers.{hostname}.{metric}
+}
+
+// enabled reports whether metrics are sent to StatsD
+func (c *StatsDConfig) enabled() bool {
+	return c.Address != ""
+}
+
+// validate checks the StatsD settings
+func (c *StatsDConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid address %q: %w", c.Address, err)
+	}
+	return validateMetricTemplate(c.Template)
+}
+
+// Lines for the StatsD sender (nil when disabled)
+var statsdQueue chan []string
+
+// startStatsDEmitter starts the StatsD sender
+func startStatsDEmitter() {
+	cfg := appConfig.StatsD
+	if !cfg.enabled() {
+		return
+	}
+	statsdQueue = make(chan []string, emitterQueueSize)
+	go runStatsDSender(cfg)
+	log.Printf("StatsD emitter enabled: %s\n", cfg.Address)
+}
+
+// emitStatsD queues a history point as StatsD gauges (called from collectHistory)
+func emitStatsD(point HistoryPoint, info *SystemInfo) {
+	if statsdQueue == nil {
+		return
+	}
+	cfg := appConfig.StatsD
+	hostname := metricHostname()
+
+	metrics := emitterMetrics(point, info)
+	lines := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		lines = append(lines, expandMetricPath(cfg.Template, hostname, m.Name)+":"+formatMetricValue(m.Value)+"|g")
+	}
+	select {
+	case statsdQueue <- lines:
+	default:
+		log.Printf("StatsD: sender is busy, dropping point\n")
+	}
+}
+
+// statsdPackets packs lines into newline-separated datagrams
+func statsdPackets(lines []string, maxSize int) [][]byte {
+	var packets [][]byte
+	var b strings.Builder
+	for _, line := range lines {
+		if b.Len() > 0 && b.Len()+1+len(line) > maxSize {
+			packets = append(packets, []byte(b.String()))
+			b.Reset()
+		}
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(line)
+	}
+	if b.Len() > 0 {
+		packets = append(packets, []byte(b.String()))
+	}
+	return packets
+}
+
+// runStatsDSender sends datagrams, redialing (and re-resolving the address) after errors
+// UDP is fire-and-forget: points that fail to send are dropped
+func runStatsDSender(cfg StatsDConfig) {
+	var conn net.Conn
+	failing := false
+
+	for lines := range statsdQueue {
+		var err error
+		if conn == nil {
+			if conn, err = net.Dial("udp", cfg.Address); err != nil {
+				conn = nil
+			}
+		}
+		if conn != nil {
+			for _, packet := range statsdPackets(lines, statsdMaxPacketSize) {
+				if _, err = conn.Write(packet); err != nil {
+					conn.Close()
+					conn = nil
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			if !failing {
+				log.Printf("StatsD: %v\n", err)
+				failing = true
+			}
+			continue
+		}
+		if failing {
+			log.Printf("StatsD: sending again\n")
+			failing = false
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Values of the history_store setting
+const (
+	historyStoreSQLite = "sqlite"
+	historyStoreFile   = "file"
+	historyStoreMemory = "memory"
+)
+
+// validateHistoryStore checks the history_store setting
+func validateHistoryStore(name string) error {
+	switch name {
+	case historyStoreSQLite, historyStoreFile, historyStoreMemory:
+		return nil
+	}
+	return fmt.Errorf("history_store must be %s, %s or %s", historyStoreSQLite, historyStoreFile, historyStoreMemory)
+}
+
+// HistoryStore persists history points for /api/history and /api/history/stats
+type HistoryStore interface {
+	Name() string
+	WritePoint(p HistoryPoint) error
+	QueryRange(startTime, endTime int64) ([]HistoryPoint, error)
+	QueryRollups(t rollupTier, startTime, endTime int64) ([]RollupPoint, error)
+	Stats() (HistoryStoreStats, error)
+	Prune(now time.Time) error // Applies the retention settings
+	Close() error
+}
+
+// HistoryTierStats describes the rows of one resolution
+type HistoryTierStats struct {
+	Count   int64
+	MinTime int64
+	MaxTime int64
+}
+
+// HistoryStoreStats describes the stored history
+// Rollups is nil for stores that compute coarser resolutions on the fly
+type HistoryStoreStats struct {
+	HistoryTierStats
+	Rollups map[string]HistoryTierStats
+}
+
+// historyStore is the active store (set by openHistoryStore)
+var historyStore HistoryStore = sqliteHistoryStore{}
+
+// openHistoryStore selects the store configured by history_store
+func openHistoryStore() error {
+	switch appConfig.HistoryStore {
+	case historyStoreFile:
+		store, err := openFileHistoryStore(filepath.Join(getDataDir(), "history"))
+		if err != nil {
+			return err
+		}
+		historyStore = store
+	case historyStoreMemory:
+		historyStore = newMemoryHistoryStore()
+	default:
+		historyStore = sqliteHistoryStore{}
+	}
+	return nil
+}
+
+// rollupsFromRange computes the buckets of a tier from raw points, for stores without rollup tables
+func rollupsFromRange(s HistoryStore, t rollupTier, startTime, endTime int64) ([]RollupPoint, error) {
+	points, err := s.QueryRange(startTime/t.Step*t.Step, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return rollupBuckets(points, t.Step), nil
+}
+
+// storesDiskHistory reports whether per-disk and disk I/O history are recorded
+// They live in SQLite tables, which the file and memory stores are selected to avoid writing
+func storesDiskHistory() bool {
+	return historyStore.Name() == historyStoreSQLite
+}
+
+// errNoDiskHistory is returned by the disk history endpoints when storesDiskHistory is false
+var errNoDiskHistory = fmt.Errorf("per-disk history is only recorded with history_store %s", historyStoreSQLite)
+
+// rawRetentionCutoff returns the oldest timestamp to keep (0 keeps everything)
+func rawRetentionCutoff(now time.Time) int64 {
+	r := appConfig.Retention.forTier("raw")
+	if r <= 0 {
+		return 0
+	}
+	return now.Add(-r).Unix()
+}
+
+// sqliteHistoryStore keeps history in the SQLite database, with rollup tables
+type sqliteHistoryStore struct{}
+
+func (sqliteHistoryStore) Name() string { return historyStoreSQLite }
+
+func (sqliteHistoryStore) WritePoint(p HistoryPoint) error {
+	return saveHistoryToDB(p)
+}
+
+func (sqliteHistoryStore) QueryRange(startTime, endTime int64) ([]HistoryPoint, error) {
+	return queryHistoryFromDB(startTime, endTime)
+}
+
+// QueryRollups fills the buckets that are not rolled up yet (up to a day for 1d) from the raw rows
+func (sqliteHistoryStore) QueryRollups(t rollupTier, startTime, endTime int64) ([]RollupPoint, error) {
+	rollups, err := queryRollupsFromDB(t, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	from := startTime / t.Step * t.Step
+	if n := len(rollups); n > 0 {
+		from = rollups[n-1].Timestamp + t.Step
+	}
+	if from > endTime {
+		return rollups, nil
+	}
+	points, err := queryHistoryFromDB(from, endTime)
+	if err != nil {
+		return nil, err
+	}
+	return append(rollups, rollupBuckets(points, t.Step)...), nil
+}
+
+func (sqliteHistoryStore) Stats() (HistoryStoreStats, error) {
+	var stats HistoryStoreStats
+	var err error
+	stats.MinTime, stats.MaxTime, stats.Count, err = getHistoryStats()
+	if err != nil {
+		return stats, err
+	}
+	stats.Rollups = make(map[string]HistoryTierStats)
+	for _, t := range rollupTiers {
+		var ts HistoryTierStats
+		if ts.MinTime, ts.MaxTime, ts.Count, err = getRollupStats(t); err != nil {
+			return stats, err
+		}
+		stats.Rollups[t.Name] = ts
+	}
+	return stats, nil
+}
+
+// Prune rolls up complete buckets first, so raw rows are never pruned before they are summarized
+func (sqliteHistoryStore) Prune(now time.Time) error {
+	return runRollups(now)
+}
+
+func (sqliteHistoryStore) Close() error { return nil }
+
+// memoryHistoryStore keeps history in a sorted slice (for tests and diskless setups)
+type memoryHistoryStore struct {
+	mu     sync.RWMutex
+	points []HistoryPoint
+}
+
+// newMemoryHistoryStore returns an empty in-memory store
+func newMemoryHistoryStore() *memoryHistoryStore {
+	return &memoryHistoryStore{}
+}
+
+func (s *memoryHistoryStore) Name() string { return historyStoreMemory }
+
+func (s *memoryHistoryStore) WritePoint(p HistoryPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i].Timestamp > p.Timestamp })
+	s.points = append(s.points, HistoryPoint{})
+	copy(s.points[i+1:], s.points[i:])
+	s.points[i] = p
+	return nil
+}
+
+func (s *memoryHistoryStore) QueryRange(startTime, endTime int64) ([]HistoryPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i].Timestamp >= startTime })
+	var result []HistoryPoint
+	for ; i < len(s.points) && s.points[i].Timestamp <= endTime; i++ {
+		result = append(result, s.points[i])
+	}
+	return result, nil
+}
+
+func (s *memoryHistoryStore) QueryRollups(t rollupTier, startTime, endTime int64) ([]RollupPoint, error) {
+	return rollupsFromRange(s, t, startTime, endTime)
+}
+
+func (s *memoryHistoryStore) Stats() (HistoryStoreStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var stats HistoryStoreStats
+	if n := len(s.points); n > 0 {
+		stats.Count = int64(n)
+		stats.MinTime = s.points[0].Timestamp
+		stats.MaxTime = s.points[n-1].Timestamp
+	}
+	return stats, nil
+}
+
+func (s *memoryHistoryStore) Prune(now time.Time) error {
+	cutoff := rawRetentionCutoff(now)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.points), func(i int) bool { return s.points[i].Timestamp >= cutoff })
+	s.points = append([]HistoryPoint(nil), s.points[i:]...)
+	return nil
+}
+
+func (s *memoryHistoryStore) Close() error { return nil }
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// One record: timestamp and the seven HistoryPoint values, little endian
+const fileHistoryRecordSize = 8 * 8
+
+// Segment files are named after their UTC day
+const fileHistoryDayLayout = "2006-01-02"
+
+// fileHistoryStore appends fixed-size records to one file per UTC day (<dir>/YYYY-MM-DD.dat)
+// Data is only ever appended and retention deletes whole days, so nothing is rewritten in place,
+// which keeps flash wear low on SD cards. Coarser resolutions are computed on the fly.
+type fileHistoryStore struct {
+	dir  string
+	mu   sync.Mutex
+	file *os.File // Segment currently appended to
+	day  string
+}
+
+// openFileHistoryStore creates the segment directory if needed
+func openFileHistoryStore(dir string) (*fileHistoryStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &fileHistoryStore{dir: dir}, nil
+}
+
+func (s *fileHistoryStore) Name() string { return historyStoreFile }
+
+// segmentDay returns the segment a timestamp belongs to
+func segmentDay(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(fileHistoryDayLayout)
+}
+
+// segments returns the days that have a segment file, oldest first
+func (s *fileHistoryStore) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, e := range entries {
+		day := strings.TrimSuffix(e.Name(), ".dat")
+		if _, err := time.Parse(fileHistoryDayLayout, day); err == nil && !e.IsDir() && strings.HasSuffix(e.Name(), ".dat") {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func (s *fileHistoryStore) segmentPath(day string) string {
+	return filepath.Join(s.dir, day+".dat")
+}
+
+// encodeHistoryRecord packs a point into one record
+func encodeHistoryRecord(p HistoryPoint) []byte {
+	buf := make([]byte, fileHistoryRecordSize)
+	binary.LittleEndian.PutUint64(buf, uint64(p.Timestamp))
+	for i, v := range historyValues(p) {
+		binary.LittleEndian.PutUint64(buf[8*(i+1):], math.Float64bits(v))
+	}
+	return buf
+}
+
+// decodeHistoryRecord unpacks one record
+func decodeHistoryRecord(buf []byte) HistoryPoint {
+	v := func(i int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(buf[8*(i+1):])) }
+	return HistoryPoint{
+		Timestamp:     int64(binary.LittleEndian.Uint64(buf)),
+		CPUPercent:    v(0),
+		MemPercent:    v(1),
+		DiskPercent:   v(2),
+		NetRxRate:     v(3),
+		NetTxRate:     v(4),
+		DiskReadRate:  v(5),
+		DiskWriteRate: v(6),
+	}
+}
+
+func (s *fileHistoryStore) WritePoint(p HistoryPoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if day := segmentDay(p.Timestamp); s.file == nil || day != s.day {
+		if s.file != nil {
+			s.file.Close()
+			s.file = nil
+		}
+		f, err := os.OpenFile(s.segmentPath(day), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		// Drop a partial record left by a crash so later records stay aligned
+		if info, err := f.Stat(); err == nil && info.Size()%fileHistoryRecordSize != 0 {
+			if err := f.Truncate(info.Size() - info.Size()%fileHistoryRecordSize); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		s.file = f
+		s.day = day
+	}
+	_, err := s.file.Write(encodeHistoryRecord(p))
+	return err
+}
+
+// readSegment returns the complete records of one day
+func (s *fileHistoryStore) readSegment(day string) ([]HistoryPoint, error) {
+	data, err := os.ReadFile(s.segmentPath(day))
+	if err != nil {
+		return nil, err
+	}
+	points := make([]HistoryPoint, 0, len(data)/fileHistoryRecordSize)
+	for off := 0; off+fileHistoryRecordSize <= len(data); off += fileHistoryRecordSize {
+		points = append(points, decodeHistoryRecord(data[off:off+fileHistoryRecordSize]))
+	}
+	return points, nil
+}
+
+func (s *fileHistoryStore) QueryRange(startTime, endTime int64) ([]HistoryPoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	first, last := segmentDay(startTime), segmentDay(endTime)
+	var result []HistoryPoint
+	for _, day := range days {
+		if day < first || day > last {
+			continue
+		}
+		points, err := s.readSegment(day)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range points {
+			if p.Timestamp >= startTime && p.Timestamp <= endTime {
+				result = append(result, p)
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Timestamp < result[j].Timestamp })
+	return result, nil
+}
+
+func (s *fileHistoryStore) QueryRollups(t rollupTier, startTime, endTime int64) ([]RollupPoint, error) {
+	return rollupsFromRange(s, t, startTime, endTime)
+}
+
+// readRecordAt reads record n of a segment (negative n counts from the end)
+func (s *fileHistoryStore) readRecordAt(day string, n int64) (HistoryPoint, bool, error) {
+	f, err := os.Open(s.segmentPath(day))
+	if err != nil {
+		return HistoryPoint{}, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return HistoryPoint{}, false, err
+	}
+	count := info.Size() / fileHistoryRecordSize
+	if n < 0 {
+		n += count
+	}
+	if n < 0 || n >= count {
+		return HistoryPoint{}, false, nil
+	}
+	buf := make([]byte, fileHistoryRecordSize)
+	if _, err := f.ReadAt(buf, n*fileHistoryRecordSize); err != nil && err != io.EOF {
+		return HistoryPoint{}, false, err
+	}
+	return decodeHistoryRecord(buf), true, nil
+}
+
+func (s *fileHistoryStore) Stats() (HistoryStoreStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats HistoryStoreStats
+	days, err := s.segments()
+	if err != nil {
+		return stats, err
+	}
+	for _, day := range days {
+		info, err := os.Stat(s.segmentPath(day))
+		if err != nil {
+			return stats, err
+		}
+		stats.Count += info.Size() / fileHistoryRecordSize
+	}
+	for _, day := range days {
+		if p, ok, err := s.readRecordAt(day, 0); err != nil {
+			return stats, err
+		} else if ok {
+			stats.MinTime = p.Timestamp
+			break
+		}
+	}
+	for i := len(days) - 1; i >= 0; i-- {
+		if p, ok, err := s.readRecordAt(days[i], -1); err != nil {
+			return stats, err
+		} else if ok {
+			stats.MaxTime = p.Timestamp
+			break
+		}
+	}
+	return stats, nil
+}
+
+// Prune deletes the segments of days that ended before the retention cutoff
+func (s *fileHistoryStore) Prune(now time.Time) error {
+	cutoff := rawRetentionCutoff(now)
+	if cutoff == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	days, err := s.segments()
+	if err != nil {
+		return err
+	}
+	keep := segmentDay(cutoff)
+	for _, day := range days {
+		if day >= keep {
+			break
+		}
+		if day == s.day && s.file != nil {
+			s.file.Close()
+			s.file = nil
+		}
+		if err := os.Remove(s.segmentPath(day)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fileHistoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
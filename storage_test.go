@@ -0,0 +1,349 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore points the global database at a fresh file in a temp directory
+// Writes are committed right away (db_flush_interval 0)
+func newTestSQLiteStore(t *testing.T) HistoryStore {
+	t.Helper()
+	prevInterval := appConfig.DBFlushInterval
+	appConfig.DBFlushInterval = 0
+	t.Cleanup(func() { appConfig.DBFlushInterval = prevInterval })
+	path := filepath.Join(t.TempDir(), "history.db")
+	conn, err := sql.Open("sqlite3", sqliteDSN(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(conn, path); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	dbMutex.Lock()
+	db = conn
+	dbMutex.Unlock()
+	t.Cleanup(func() {
+		dbMutex.Lock()
+		db = nil
+		dbMutex.Unlock()
+		conn.Close()
+	})
+	return sqliteHistoryStore{}
+}
+
+func newTestFileStore(t *testing.T) HistoryStore {
+	t.Helper()
+	store, err := openFileHistoryStore(filepath.Join(t.TempDir(), "history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// historyStoreFactories lists every store implementation
+var historyStoreFactories = []struct {
+	name string
+	open func(t *testing.T) HistoryStore
+}{
+	{historyStoreMemory, func(t *testing.T) HistoryStore { return newMemoryHistoryStore() }},
+	{historyStoreFile, newTestFileStore},
+	{historyStoreSQLite, newTestSQLiteStore},
+}
+
+// withRawRetention sets the raw retention for the duration of a test
+func withRawRetention(t *testing.T, r time.Duration) {
+	t.Helper()
+	prev := appConfig.Retention
+	appConfig.Retention = RetentionConfig{Raw: Duration(r)}
+	t.Cleanup(func() { appConfig.Retention = prev })
+}
+
+func testPoint(ts time.Time, cpu float64) HistoryPoint {
+	return HistoryPoint{
+		Timestamp:     ts.Unix(),
+		CPUPercent:    cpu,
+		MemPercent:    50.5,
+		DiskPercent:   20.25,
+		NetRxRate:     1024,
+		NetTxRate:     2048,
+		DiskReadRate:  4096,
+		DiskWriteRate: 8192,
+	}
+}
+
+func timestamps(points []HistoryPoint) []int64 {
+	ts := make([]int64, 0, len(points))
+	for _, p := range points {
+		ts = append(ts, p.Timestamp)
+	}
+	return ts
+}
+
+func TestHistoryStores(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	// Written out of order; the two around midnight straddle a UTC day boundary
+	points := []HistoryPoint{
+		testPoint(now.Add(-time.Hour), 10),
+		testPoint(time.Date(2026, 10, 11, 6, 0, 0, 0, time.UTC), 20),
+		testPoint(time.Date(2026, 10, 13, 23, 59, 59, 0, time.UTC), 30),
+		testPoint(time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC), 40),
+		testPoint(time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC), 50),
+	}
+	all := []int64{
+		time.Date(2026, 10, 11, 6, 0, 0, 0, time.UTC).Unix(),
+		time.Date(2026, 10, 13, 23, 59, 59, 0, time.UTC).Unix(),
+		time.Date(2026, 10, 14, 13, 0, 0, 0, time.UTC).Unix(),
+		time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC).Unix(),
+		now.Add(-time.Hour).Unix(),
+	}
+
+	for _, f := range historyStoreFactories {
+		t.Run(f.name, func(t *testing.T) {
+			store := f.open(t)
+			if store.Name() != f.name {
+				t.Fatalf("Name() = %q, want %q", store.Name(), f.name)
+			}
+
+			stats, err := store.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.HistoryTierStats != (HistoryTierStats{}) {
+				t.Errorf("empty store stats = %+v", stats.HistoryTierStats)
+			}
+
+			for _, p := range points {
+				if err := store.WritePoint(p); err != nil {
+					t.Fatalf("WritePoint(%d): %v", p.Timestamp, err)
+				}
+			}
+
+			queries := []struct {
+				name       string
+				start, end int64
+				want       []int64
+			}{
+				{"everything", 0, now.Unix(), all},
+				{"one day", time.Date(2026, 10, 13, 0, 0, 0, 0, time.UTC).Unix(), time.Date(2026, 10, 13, 23, 59, 59, 0, time.UTC).Unix(), all[1:2]},
+				{"across midnight", all[1], all[3], all[1:4]},
+				{"bounds are inclusive", all[2], all[2], all[2:3]},
+				{"before the data", 0, all[0] - 1, []int64{}},
+				{"after the data", now.Unix(), now.Add(time.Hour).Unix(), []int64{}},
+			}
+			for _, q := range queries {
+				got, err := store.QueryRange(q.start, q.end)
+				if err != nil {
+					t.Fatalf("%s: %v", q.name, err)
+				}
+				if ts := timestamps(got); !reflect.DeepEqual(ts, q.want) {
+					t.Errorf("%s: got %v, want %v", q.name, ts, q.want)
+				}
+			}
+
+			got, err := store.QueryRange(now.Add(-time.Hour).Unix(), now.Unix())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != points[0] {
+				t.Errorf("point did not round-trip: got %+v, want %+v", got, points[0])
+			}
+
+			stats, err = store.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (HistoryTierStats{Count: int64(len(all)), MinTime: all[0], MaxTime: all[len(all)-1]}); stats.HistoryTierStats != want {
+				t.Errorf("stats = %+v, want %+v", stats.HistoryTierStats, want)
+			}
+
+			// The cutoff (2026-10-14 12:00) is inside a day, so the file store's whole-day pruning
+			// and the exact cutoff of the other stores agree on these points
+			withRawRetention(t, 48*time.Hour)
+			if err := store.Prune(now); err != nil {
+				t.Fatal(err)
+			}
+			got, err = store.QueryRange(0, now.Unix())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ts := timestamps(got); !reflect.DeepEqual(ts, all[2:]) {
+				t.Errorf("after Prune: got %v, want %v", ts, all[2:])
+			}
+			stats, err = store.Stats()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (HistoryTierStats{Count: 3, MinTime: all[2], MaxTime: all[4]}); stats.HistoryTierStats != want {
+				t.Errorf("stats after Prune = %+v, want %+v", stats.HistoryTierStats, want)
+			}
+
+			// Writing continues after pruning
+			next := testPoint(now, 60)
+			if err := store.WritePoint(next); err != nil {
+				t.Fatal(err)
+			}
+			got, err = store.QueryRange(now.Unix(), now.Unix())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != next {
+				t.Errorf("write after Prune: got %+v", got)
+			}
+		})
+	}
+}
+
+func TestHistoryStoresPruneWithoutRetention(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	for _, f := range historyStoreFactories {
+		t.Run(f.name, func(t *testing.T) {
+			store := f.open(t)
+			withRawRetention(t, 0)
+			old := testPoint(now.AddDate(-1, 0, 0), 1)
+			if err := store.WritePoint(old); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Prune(now); err != nil {
+				t.Fatal(err)
+			}
+			got, err := store.QueryRange(0, now.Unix())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Errorf("a retention of 0 must keep everything, got %d points", len(got))
+			}
+		})
+	}
+}
+
+func TestFileHistoryStoreDaySegments(t *testing.T) {
+	// Segments are named after the UTC day whatever the local time zone
+	prevLocal := time.Local
+	time.Local = time.FixedZone("UTC-5", -5*60*60)
+	t.Cleanup(func() { time.Local = prevLocal })
+
+	dir := filepath.Join(t.TempDir(), "history")
+	store, err := openFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	lastSecond := time.Date(2026, 10, 15, 23, 59, 59, 0, time.UTC)
+	midnight := lastSecond.Add(time.Second)
+	for _, p := range []HistoryPoint{testPoint(lastSecond, 1), testPoint(midnight, 2)} {
+		if err := store.WritePoint(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for day, want := range map[string]int64{"2026-10-15": lastSecond.Unix(), "2026-10-16": midnight.Unix()} {
+		data, err := os.ReadFile(filepath.Join(dir, day+".dat"))
+		if err != nil {
+			t.Fatalf("segment %s: %v", day, err)
+		}
+		if len(data) != fileHistoryRecordSize {
+			t.Fatalf("segment %s holds %d bytes, want one record", day, len(data))
+		}
+		if ts := decodeHistoryRecord(data).Timestamp; ts != want {
+			t.Errorf("segment %s holds %d, want %d", day, ts, want)
+		}
+	}
+
+	got, err := store.QueryRange(midnight.Unix(), midnight.Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := timestamps(got); !reflect.DeepEqual(ts, []int64{midnight.Unix()}) {
+		t.Errorf("query from midnight: got %v", ts)
+	}
+
+	// Pruning with the cutoff right at midnight drops the previous day only
+	withRawRetention(t, time.Hour)
+	if err := store.Prune(midnight.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2026-10-15.dat")); !os.IsNotExist(err) {
+		t.Errorf("segment 2026-10-15 was not pruned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2026-10-16.dat")); err != nil {
+		t.Errorf("segment 2026-10-16: %v", err)
+	}
+}
+
+func TestFileHistoryStoreTornRecord(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	store, err := openFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	first, second := testPoint(base, 1), testPoint(base.Add(10*time.Second), 2)
+	for _, p := range []HistoryPoint{first, second} {
+		if err := store.WritePoint(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// Simulate a crash in the middle of appending a third record
+	path := filepath.Join(dir, "2026-10-16.dat")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(encodeHistoryRecord(testPoint(base.Add(20*time.Second), 3))[:fileHistoryRecordSize/2+3]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	store, err = openFileHistoryStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// Readers ignore the partial record
+	got, err := store.QueryRange(0, base.Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []HistoryPoint{first, second}) {
+		t.Errorf("before the next write: got %+v", got)
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (HistoryTierStats{Count: 2, MinTime: first.Timestamp, MaxTime: second.Timestamp}); stats.HistoryTierStats != want {
+		t.Errorf("stats = %+v, want %+v", stats.HistoryTierStats, want)
+	}
+
+	// The next write drops it so the new record stays aligned
+	third := testPoint(base.Add(30*time.Second), 4)
+	if err := store.WritePoint(third); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 3*fileHistoryRecordSize {
+		t.Errorf("segment size = %d, want %d", info.Size(), 3*fileHistoryRecordSize)
+	}
+	got, err = store.QueryRange(0, base.Add(time.Hour).Unix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []HistoryPoint{first, second, third}) {
+		t.Errorf("after the next write: got %+v", got)
+	}
+}
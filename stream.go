@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Stream configuration
+const (
+	streamClientBuffer    = 4                // Snapshots queued per client before dropping
+	streamUpdateBuffer    = 4                // Updates queued for the publisher before dropping
+	streamKeepAlive       = 15 * time.Second // SSE comment / WebSocket ping interval
+	streamWriteTimeout    = 10 * time.Second
+	streamMaxMessageBytes = 4096
+)
+
+// streamSections lists the sections a client can subscribe to
+var streamSections = []string{"host", "cpu", "memory", "disk", "disks", "temperature", "network", "disk_io", "history"}
+
+// streamClient is a single SSE or WebSocket subscriber
+type streamClient struct {
+	ch       chan map[string]json.RawMessage
+	mu       sync.Mutex
+	sections map[string]bool // nil means all sections
+}
+
+var (
+	streamClients      = make(map[*streamClient]bool)
+	streamClientsMutex sync.Mutex
+	streamUpdates      = make(chan *HistoryPoint, streamUpdateBuffer)
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// parseStreamSections parses a comma-separated section list (empty means all)
+func parseStreamSections(list []string) (map[string]bool, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	sections := make(map[string]bool)
+	for _, name := range list {
+		valid := false
+		for _, s := range streamSections {
+			if s == name {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown section %q (valid: %s)", name, strings.Join(streamSections, ","))
+		}
+		sections[name] = true
+	}
+	return sections, nil
+}
+
+// setSections replaces the client's subscription
+func (c *streamClient) setSections(sections map[string]bool) {
+	c.mu.Lock()
+	c.sections = sections
+	c.mu.Unlock()
+}
+
+// filter returns the snapshot restricted to the subscribed sections
+// (nil if none of them are present in this snapshot)
+func (c *streamClient) filter(snapshot map[string]json.RawMessage) map[string]json.RawMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sections == nil {
+		return snapshot
+	}
+	result := make(map[string]json.RawMessage)
+	for name := range c.sections {
+		if v, ok := snapshot[name]; ok {
+			result[name] = v
+		}
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	result["timestamp"] = snapshot["timestamp"]
+	return result
+}
+
+func addStreamClient(c *streamClient) {
+	streamClientsMutex.Lock()
+	streamClients[c] = true
+	streamClientsMutex.Unlock()
+}
+
+func removeStreamClient(c *streamClient) {
+	streamClientsMutex.Lock()
+	delete(streamClients, c)
+	streamClientsMutex.Unlock()
+}
+
+// buildStreamSnapshot assembles the current snapshot (point is set on history ticks)
+func buildStreamSnapshot(point *HistoryPoint) (map[string]json.RawMessage, error) {
+	info, err := getCachedSystemInfo()
+	if err != nil {
+		return nil, err
+	}
+	// The system info cache may be older than the latest CPU sample
+	current := *info
+	current.CPU.UsagePercent = getCachedCPUPercent()
+	current.Network = getCachedNetworkInfo()
+	current.DiskIO = getCachedDiskIOInfo()
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot map[string]json.RawMessage
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	if point != nil {
+		if snapshot["history"], err = json.Marshal(point); err != nil {
+			return nil, err
+		}
+	}
+	snapshot["timestamp"], _ = json.Marshal(time.Now().Unix())
+	return snapshot, nil
+}
+
+// publishStream hands an update to the stream publisher without blocking the collector
+// (point is set on history ticks, nil for CPU samples)
+func publishStream(point *HistoryPoint) {
+	select {
+	case streamUpdates <- point:
+	default:
+	}
+}
+
+// startStreamPublisher builds and broadcasts snapshots off the collector goroutines,
+// since a snapshot may have to refresh the system info cache
+func startStreamPublisher() {
+	go func() {
+		for point := range streamUpdates {
+			broadcastStream(point)
+		}
+	}()
+}
+
+// broadcastStream pushes a new snapshot to all stream subscribers
+func broadcastStream(point *HistoryPoint) {
+	streamClientsMutex.Lock()
+	if len(streamClients) == 0 {
+		streamClientsMutex.Unlock()
+		return
+	}
+	clients := make([]*streamClient, 0, len(streamClients))
+	for c := range streamClients {
+		clients = append(clients, c)
+	}
+	streamClientsMutex.Unlock()
+
+	snapshot, err := buildStreamSnapshot(point)
+	if err != nil {
+		return
+	}
+	for _, c := range clients {
+		msg := c.filter(snapshot)
+		if msg == nil {
+			continue
+		}
+		// Slow clients miss updates rather than blocking the collectors
+		select {
+		case c.ch <- msg:
+		default:
+		}
+	}
+}
+
+// handleStream serves live snapshots as Server-Sent Events or over WebSocket
+// Query params:
+//   - sections: comma-separated list (default: all)
+//   - transport: "sse" (default) or "ws" (also selected by a WebSocket upgrade request)
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var list []string
+	if s := query.Get("sections"); s != "" {
+		list = splitList(s)
+	}
+	sections, err := parseStreamSections(list)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	client := &streamClient{
+		ch:       make(chan map[string]json.RawMessage, streamClientBuffer),
+		sections: sections,
+	}
+
+	// Send an initial snapshot so clients render immediately
+	if snapshot, err := buildStreamSnapshot(nil); err == nil {
+		if msg := client.filter(snapshot); msg != nil {
+			client.ch <- msg
+		}
+	}
+
+	if websocket.IsWebSocketUpgrade(r) || query.Get("transport") == "ws" {
+		serveStreamWebSocket(w, r, client)
+		return
+	}
+	serveStreamSSE(w, r, client)
+}
+
+// serveStreamSSE writes snapshots as text/event-stream
+func serveStreamSSE(w http.ResponseWriter, r *http.Request, client *streamClient) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	flusher.Flush()
+
+	addStreamClient(client)
+	defer removeStreamClient(client)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case msg := <-client.ch:
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// serveStreamWebSocket writes snapshots as WebSocket text messages
+// Clients may send {"sections": [...]} to change their subscription
+func serveStreamWebSocket(w http.ResponseWriter, r *http.Request, client *streamClient) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade error: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	addStreamClient(client)
+	defer removeStreamClient(client)
+
+	// Reader: subscription changes and connection close
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn.SetReadLimit(streamMaxMessageBytes)
+		for {
+			var req struct {
+				Sections []string `json:"sections"`
+			}
+			if err := conn.ReadJSON(&req); err != nil {
+				if _, ok := err.(*json.SyntaxError); ok {
+					continue
+				}
+				return
+			}
+			sections, err := parseStreamSections(req.Sections)
+			if err != nil {
+				continue
+			}
+			client.setSections(sections)
+		}
+	}()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-keepAlive.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return
+			}
+		case msg := <-client.ch:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		}
+	}
+}
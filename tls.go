@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLS settings
+const (
+	tlsReloadInterval    = 30 * time.Second     // How often cert/key/CA files are checked for changes
+	selfSignedCertExpiry = 365 * 24 * time.Hour // Validity of generated certificates
+	selfSignedRenewAfter = selfSignedCertExpiry - 30*24*time.Hour
+	selfSignedOrg        = "System Monitor" // Subject organization marking certificates generated here
+)
+
+// TLSConfig is the "tls" section of the config file
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"` // Require client certificates signed by this CA bundle (mTLS)
+	SelfSigned   bool   `json:"self_signed"`    // Generate cert_file/key_file if they do not exist
+}
+
+// enabled reports whether the server should serve HTTPS
+func (t TLSConfig) enabled() bool {
+	return t.CertFile != "" || t.SelfSigned
+}
+
+// validate checks that the TLS settings are consistent
+func (t *TLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if t.ClientCAFile != "" {
+		if !t.enabled() {
+			return fmt.Errorf("client_ca_file requires cert_file/key_file or self_signed")
+		}
+		if _, err := os.Stat(t.ClientCAFile); err != nil {
+			return fmt.Errorf("client_ca_file: %w", err)
+		}
+	}
+	// Missing files are generated in self-signed mode
+	if t.CertFile != "" && !t.SelfSigned {
+		for _, path := range []string{t.CertFile, t.KeyFile} {
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// certReloader serves the current certificate and client CA pool,
+// reloading them when the files change on disk
+type certReloader struct {
+	cfg       TLSConfig
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	warned    bool // A foreign certificate is due for renewal and was already reported
+}
+
+// newCertReloader loads the certificate (generating it first in self-signed mode)
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	if cfg.SelfSigned {
+		if err := ensureSelfSignedCert(cfg.CertFile, cfg.KeyFile); err != nil {
+			return nil, err
+		}
+	}
+	r := &certReloader{cfg: cfg, modTimes: make(map[string]time.Time)}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the files watched for changes
+func (r *certReloader) files() []string {
+	files := []string{r.cfg.CertFile, r.cfg.KeyFile}
+	if r.cfg.ClientCAFile != "" {
+		files = append(files, r.cfg.ClientCAFile)
+	}
+	return files
+}
+
+// changed reports whether any watched file has a new modification time
+func (r *certReloader) changed() bool {
+	for _, path := range r.files() {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// reload reads the certificate, key and client CA bundle from disk
+func (r *certReloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, path := range r.files() {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", r.cfg.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.modTimes = modTimes
+	r.warned = false
+	r.mu.Unlock()
+	return nil
+}
+
+// watch polls the files and reloads them after a change
+// A broken file keeps the previous certificate in use
+func (r *certReloader) watch() {
+	ticker := time.NewTicker(tlsReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if r.cfg.SelfSigned {
+			r.renewSelfSigned()
+		}
+		r.mu.RLock()
+		changed := r.changed()
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.reload(); err != nil {
+			log.Printf("TLS reload failed, keeping previous certificate: %v\n", err)
+			continue
+		}
+		log.Printf("TLS certificate reloaded from %s\n", r.cfg.CertFile)
+	}
+}
+
+// renewSelfSigned regenerates the certificate when it was generated here and is due for renewal
+// Any other certificate is left alone and reported once until the file changes
+func (r *certReloader) renewSelfSigned() {
+	cert, err := readCertFile(r.cfg.CertFile)
+	if err != nil || !selfSignedRenewalDue(cert) {
+		return
+	}
+	if !isGeneratedCert(cert) {
+		r.mu.Lock()
+		warned := r.warned
+		r.warned = true
+		r.mu.Unlock()
+		if !warned {
+			log.Printf("Warning: %s was not generated by self_signed mode and is not renewed automatically (expires %s)\n",
+				r.cfg.CertFile, cert.NotAfter.Format("2006-01-02"))
+		}
+		return
+	}
+	if err := generateSelfSignedCert(r.cfg.CertFile, r.cfg.KeyFile); err != nil {
+		log.Printf("Self-signed certificate renewal failed: %v\n", err)
+	}
+}
+
+// tlsConfig returns a server config that always uses the latest certificate and CA pool
+// NextProtos is set up front so the per-client clones keep HTTP/2
+func (r *certReloader) tlsConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.cert, nil
+		},
+	}
+	if r.cfg.ClientCAFile != "" {
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := base.Clone()
+			cfg.GetConfigForClient = nil
+			cfg.ClientCAs = r.clientCAs
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			return cfg, nil
+		}
+	}
+	return base
+}
+
+// ensureSelfSignedCert generates a certificate unless a valid one already exists
+// An existing certificate is only replaced if it was generated here
+func ensureSelfSignedCert(certFile, keyFile string) error {
+	cert, err := readCertFile(certFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		return generateSelfSignedCert(certFile, keyFile)
+	}
+	if !isGeneratedCert(cert) {
+		log.Printf("Warning: %s was not generated by self_signed mode and is not renewed automatically\n", certFile)
+		return nil
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && !selfSignedRenewalDue(cert) {
+		return nil
+	}
+	return generateSelfSignedCert(certFile, keyFile)
+}
+
+// readCertFile parses the first certificate in a PEM file
+func readCertFile(certFile string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s: no PEM certificate found", certFile)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// isGeneratedCert reports whether a certificate was written by generateSelfSignedCert:
+// self-issued, signed by its own key and carrying our subject organization
+// (the common name is not compared because the hostname may have changed since)
+func isGeneratedCert(cert *x509.Certificate) bool {
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+		return false
+	}
+	if len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != selfSignedOrg {
+		return false
+	}
+	return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// selfSignedRenewalDue reports whether a generated certificate is due for renewal
+func selfSignedRenewalDue(cert *x509.Certificate) bool {
+	return time.Now().After(cert.NotBefore.Add(selfSignedRenewAfter))
+}
+
+// generateSelfSignedCert writes a new ECDSA P-256 certificate for this host
+func generateSelfSignedCert(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	dnsNames := []string{"localhost"}
+	if hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{selfSignedOrg}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCertExpiry),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	// Write the key first so the cert/key pair is never mismatched for long
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	log.Printf("Generated self-signed certificate %s (valid until %s)\n", certFile, template.NotAfter.Format("2006-01-02"))
+	return nil
+}